@@ -0,0 +1,190 @@
+//Filename: kriol/backend/kriol/cmd/api/admin.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// The listUsersHandler() lets an admin search the user accounts
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	//Create an input struct to hold our query parameters
+	var input struct {
+		Name      string
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	//Initialize a validator
+	v := validator.New()
+	//Get the URL values map
+	qs := r.URL.Query()
+	//Use the helper methods to extract the values
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	//Get the page information
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//Get the sort information
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	//Specify the allowed sort values
+	input.Filters.SortList = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	//Checking for validation errors
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readUserParam() method fetches the user named by the id in the URL, sending
+// the error response itself when that fails
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// The showUserHandler() shows an admin a single account with its roles, permissions and lock status
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	sessions, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user":        user,
+		"permissions": permissions,
+		"roles":       roles,
+		"sessions":    len(sessions),
+		"locked":      !lockedUntil.IsZero(),
+	}
+	if !lockedUntil.IsZero() {
+		env["locked_until"] = lockedUntil
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateUserHandler() lets an admin activate an account, or disable and re-enable it.
+// Disabling logs the user out everywhere and revokes their API keys
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+		Disabled  *bool `json:"disabled"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil || input.Disabled != nil, "activated", "activated or disabled must be provided")
+	v.Check(input.Activated == nil || *input.Activated, "activated", "cannot be unset, disable the account instead")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+	if input.Disabled != nil {
+		user.Disabled = *input.Disabled
+	}
+
+	err = app.models.Users.UpdateStatus(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteUserTokensHandler() logs a user out everywhere
+func (app *application) deleteUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully logged out of every session"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
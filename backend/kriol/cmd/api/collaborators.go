@@ -0,0 +1,150 @@
+//Filename: kriol/backend/kriol/cmd/api/collaborators.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// The readSchoolParam() method fetches the school named by the id in the URL and
+// checks the user may manage it, sending the error response itself when that fails
+func (app *application) readSchoolParam(w http.ResponseWriter, r *http.Request, allowCollaborators bool) (*data.School, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	school, err := app.models.Schools.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	ok, err := app.canEditSchool(r, school, allowCollaborators)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+	return school, true
+}
+
+// The listCollaboratorsHandler() lists the users a school has been shared with
+func (app *application) listCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	school, ok := app.readSchoolParam(w, r, true)
+	if !ok {
+		return
+	}
+
+	collaborators, err := app.models.Schools.GetCollaborators(school.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"owner_id": school.OwnerID, "collaborators": collaborators}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addCollaboratorHandler() lets the owner share a school with another user by email.
+// The response is the same whether or not the address has an account, so this endpoint
+// does not answer that question directly. The collaborator listing still shows who the
+// school was shared with, so an owner willing to look can tell afterwards. That is the
+// price of sharing by email, and requests are rate limited like every other endpoint
+func (app *application) addCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	school, ok := app.readSchoolParam(w, r, false)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//The owner already knows their own address, so this can be an error
+	owner := app.contextGetUser(r)
+	if school.OwnerID != nil && *school.OwnerID == owner.ID && strings.EqualFold(owner.Email, input.Email) {
+		v.AddError("email", "the owner of a school cannot be a collaborator")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	//Unknown addresses and the owner get the same response as a successful share
+	if user != nil && (school.OwnerID == nil || *school.OwnerID != user.ID) {
+		err = app.models.Schools.AddCollaborator(school.ID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "if an account with this email address exists, the school has been shared with it"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeCollaboratorHandler() stops sharing a school with a user. Collaborators
+// may also remove themselves
+func (app *application) removeCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	self := app.contextGetUser(r).ID == userID
+	school, ok := app.readSchoolParam(w, r, self)
+	if !ok {
+		return
+	}
+
+	err = app.models.Schools.RemoveCollaborator(school.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collaborator successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -96,8 +99,34 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// Accounts an admin has disabled
+func (app *application) disabledAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been disabled"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // User does not have the required permission
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user accound does not have the necessary permission to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// The resource needs a user session and cannot be reached with an API key
+func (app *application) sessionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource cannot be accessed with an API key, please sign in"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// Too many failed logins in a short time
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The account is temporarily locked
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(lockedUntil).Seconds()))))
+	message := "your user account has been temporarily locked because of too many failed login attempts"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -0,0 +1,234 @@
+//Filename: kriol/backend/kriol/cmd/api/exports.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// How long writing one part of an export may take before the client is given up on
+const exportWriteTimeout = 30 * time.Second
+
+// The content type and file extension of each export format
+var exportFormats = map[string][2]string{
+	"csv":     {"text/csv; charset=utf-8", "csv"},
+	"ndjson":  {"application/x-ndjson", "ndjson"},
+	"geojson": {"application/geo+json", "geojson"},
+}
+
+// A schoolFeature is a school written as a GeoJSON feature
+type schoolFeature struct {
+	Type       string       `json:"type"`
+	ID         int64        `json:"id"`
+	Geometry   interface{}  `json:"geometry"`
+	Properties *data.School `json:"properties"`
+}
+
+// The message written at the end of an export that failed part way through
+const exportFailedMessage = "the export failed before it was complete, this file is missing schools"
+
+// The exportSchoolsHandler() streams the whole school directory, or the part matching
+// the name, level and mode filters, as CSV, NDJSON or GeoJSON.
+//
+// Once the first school is sent the status can no longer change, so an export that
+// fails part way through ends with a marker instead: a CSV row starting with #error,
+// an NDJSON line with an "error" member, or an "error" member next to the GeoJSON
+// features. The X-Export-Status trailer is also set to "failed", or "complete" when
+// every school was sent
+func (app *application) exportSchoolsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string
+		Level  string
+		Mode   []string
+		Format string
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Name = app.readString(qs, "name", "")
+	input.Level = app.readString(qs, "level", "")
+	input.Mode = app.readCSV(qs, "mode", []string{})
+	input.Format = app.readString(qs, "format", "csv")
+	_, ok := exportFormats[input.Format]
+	if v.Check(ok, "format", "must be csv, ndjson or geojson"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Nothing is sent until the first school is ready, so an error before
+	//that can still get a proper response
+	buf := bufio.NewWriter(w)
+	rc := http.NewResponseController(w)
+	started := false
+	start := func() {
+		format := exportFormats[input.Format]
+		w.Header().Set("Content-Type", format[0])
+		w.Header().Set("Content-Disposition", `attachment; filename="schools.`+format[1]+`"`)
+		w.Header().Set("Trailer", "X-Export-Status")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+	//The server's write timeout would cut a long export short, so the deadline is
+	//pushed back every time a part is sent. A client that stops reading still times out
+	extendDeadline := func() {
+		err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			app.logError(r, err)
+		}
+	}
+	extendDeadline()
+
+	var encode func(*data.School) error
+	var finish func() error
+	var abort func() error
+	switch input.Format {
+	case "csv":
+		writer := csv.NewWriter(buf)
+		header := []string{"id", "name", "level", "contact", "phone", "email", "website", "address", "mode", "latitude", "longitude"}
+		encode = func(school *data.School) error {
+			if header != nil {
+				if err := writer.Write(header); err != nil {
+					return err
+				}
+				header = nil
+			}
+			return writer.Write([]string{
+				strconv.FormatInt(school.ID, 10), school.Name, school.Level, school.Contact, school.Phone,
+				school.Email, school.Website, school.Address, strings.Join(school.Mode, ";"),
+				formatCoordinate(school.Latitude), formatCoordinate(school.Longitude),
+			})
+		}
+		finish = func() error {
+			if header != nil {
+				if err := writer.Write(header); err != nil {
+					return err
+				}
+			}
+			writer.Flush()
+			return writer.Error()
+		}
+		abort = func() error {
+			if err := writer.Write([]string{"#error", exportFailedMessage}); err != nil {
+				return err
+			}
+			writer.Flush()
+			return writer.Error()
+		}
+	case "ndjson":
+		enc := json.NewEncoder(buf)
+		encode = func(school *data.School) error {
+			return enc.Encode(school)
+		}
+		finish = func() error { return nil }
+		abort = func() error {
+			return enc.Encode(map[string]string{"error": exportFailedMessage})
+		}
+	case "geojson":
+		enc := json.NewEncoder(buf)
+		separator := `{"type":"FeatureCollection","features":[`
+		encode = func(school *data.School) error {
+			if _, err := buf.WriteString(separator); err != nil {
+				return err
+			}
+			separator = ","
+			feature := schoolFeature{Type: "Feature", ID: school.ID, Properties: school}
+			//Schools without a location are still exported, with a null geometry
+			if school.Latitude != nil && school.Longitude != nil {
+				feature.Geometry = map[string]interface{}{
+					"type":        "Point",
+					"coordinates": []float64{*school.Longitude, *school.Latitude},
+				}
+			}
+			return enc.Encode(feature)
+		}
+		finish = func() error {
+			if separator != "," {
+				if _, err := buf.WriteString(separator); err != nil {
+					return err
+				}
+			}
+			_, err := buf.WriteString("]}\n")
+			return err
+		}
+		abort = func() error {
+			if separator != "," {
+				if _, err := buf.WriteString(separator); err != nil {
+					return err
+				}
+			}
+			message, err := json.Marshal(exportFailedMessage)
+			if err != nil {
+				return err
+			}
+			_, err = buf.WriteString(`],"error":` + string(message) + "}\n")
+			return err
+		}
+	}
+
+	count := 0
+	err := app.models.Schools.Export(r.Context(), input.Name, input.Level, input.Mode, func(school *data.School) error {
+		if !started {
+			start()
+		}
+		err := encode(school)
+		if err != nil {
+			return err
+		}
+		//Push what we have to the client every so often
+		count++
+		if count%500 == 0 {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			extendDeadline()
+		}
+		return nil
+	})
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		//The status has been sent, all we can do is mark the export as incomplete
+		app.logError(r, err)
+		if err := abort(); err == nil {
+			buf.Flush()
+		}
+		w.Header().Set("X-Export-Status", "failed")
+		return
+	}
+
+	if !started {
+		start()
+	}
+	err = finish()
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+		w.Header().Set("X-Export-Status", "failed")
+		return
+	}
+	w.Header().Set("X-Export-Status", "complete")
+}
+
+// The formatCoordinate() function writes a coordinate for CSV, an unset one is left empty
+func formatCoordinate(coordinate *float64) string {
+	if coordinate == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*coordinate, 'f', -1, 64)
+}
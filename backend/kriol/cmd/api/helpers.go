@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -149,6 +150,47 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return intValue
 }
 
+// The readFloat() method converts a string value from the query string to a float value
+// if the value cannot be converted to a float then a validation error is added to the validation errors map
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	//Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return floatValue
+}
+
+// The readBool() method converts a string value from the query string to a boolean value.
+// if no matching key is found then nil is returned
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	//Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return nil
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+	return &boolValue
+}
+
+// The clientIP() method returns the IP address of the client without the port
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 // background accepts a function as it's parameter
 func (app *application) background(fn func()) {
 	//increament the WaitGroup counter
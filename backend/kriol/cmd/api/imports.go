@@ -0,0 +1,293 @@
+//Filename: kriol/backend/kriol/cmd/api/imports.go
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// The CSV header names we understand and the School field each one fills
+var schoolImportColumns = map[string]string{
+	"name":           "name",
+	"school":         "name",
+	"school name":    "name",
+	"level":          "level",
+	"contact":        "contact",
+	"contact person": "contact",
+	"phone":          "phone",
+	"telephone":      "phone",
+	"email":          "email",
+	"website":        "website",
+	"address":        "address",
+	"mode":           "mode",
+	"modes":          "mode",
+	"latitude":       "latitude",
+	"lat":            "latitude",
+	"longitude":      "longitude",
+	"lng":            "longitude",
+	"lon":            "longitude",
+}
+
+// An importRowError holds the validation errors of one CSV row. Row is the line
+// number in the file, the header being line 1
+type importRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// The importReport is what the client gets back from an import
+type importReport struct {
+	DryRun         bool             `json:"dry_run"`
+	Rows           int              `json:"rows"`
+	Skipped        int              `json:"skipped"`
+	Valid          int              `json:"valid"`
+	Inserted       int              `json:"inserted"`
+	Errors         []importRowError `json:"errors"`
+	IgnoredColumns []string         `json:"ignored_columns"`
+	ResumeFrom     int              `json:"resume_from,omitempty"`
+}
+
+// The importSchoolsHandler() creates schools from an uploaded CSV file. Every row is
+// validated and invalid rows are reported, valid rows are inserted in chunks that each
+// commit on their own. A row with the same name and address as a school already in the
+// directory, or as an earlier row, is reported as an error so sending a file twice does
+// not create every school twice.
+//
+// If a chunk fails the response is a 500 with the body
+//
+//	{"error": "...", "import": {..., "inserted": 1000, "resume_from": 1002}}
+//
+// and the same file can be sent again with ?resume_from=1002 to carry on from the
+// first row that was not inserted
+func (app *application) importSchoolsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		DryRun     bool
+		ChunkSize  int
+		ResumeFrom int
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	if dryRun := app.readBool(qs, "dry_run", v); dryRun != nil {
+		input.DryRun = *dryRun
+	}
+	input.ChunkSize = app.readInt(qs, "chunk_size", 500, v)
+	input.ResumeFrom = app.readInt(qs, "resume_from", 0, v)
+	v.Check(input.ChunkSize >= 1, "chunk_size", "must be greater than zero")
+	v.Check(input.ChunkSize <= 5000, "chunk_size", "must be a maximum of 5000")
+	v.Check(input.ResumeFrom >= 0, "resume_from", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	file, err := app.readUpload(w, r, "file")
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	//Work out which column fills which field
+	header, err := reader.Read()
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("could not read the CSV header: %w", err))
+		return
+	}
+	report := importReport{DryRun: input.DryRun, Errors: []importRowError{}, IgnoredColumns: []string{}}
+	fields := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, column := range header {
+		column = strings.TrimPrefix(column, "\ufeff")
+		normalized := strings.Join(strings.Fields(strings.NewReplacer("_", " ", "-", " ").Replace(strings.ToLower(column))), " ")
+		field, ok := schoolImportColumns[normalized]
+		if !ok {
+			report.IgnoredColumns = append(report.IgnoredColumns, column)
+			continue
+		}
+		v.Check(!seen[field], "file", fmt.Sprintf("has more than one column for %s", field))
+		seen[field] = true
+		fields[i] = field
+	}
+	for _, field := range []string{"name", "level", "contact", "phone", "email", "website", "address", "mode"} {
+		v.Check(seen[field], "file", fmt.Sprintf("must have a column for %s", field))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Validate every row, keeping the line each valid school came from
+	owner := app.contextGetUser(r)
+	var schools []*data.School
+	var lines []int
+	inFile := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("could not read the CSV file: %w", err))
+			return
+		}
+		line, _ := reader.FieldPos(0)
+		report.Rows++
+		if line < input.ResumeFrom {
+			report.Skipped++
+			continue
+		}
+
+		school := &data.School{OwnerID: &owner.ID}
+		rowValidator := validator.New()
+		if len(record) != len(header) {
+			rowValidator.AddError("row", fmt.Sprintf("has %d fields, expected %d", len(record), len(header)))
+		} else {
+			for i, value := range record {
+				err := setSchoolField(school, fields[i], strings.TrimSpace(value))
+				if err != nil {
+					rowValidator.AddError(fields[i], "must be a number")
+				}
+			}
+			data.ValidateSchool(rowValidator, school)
+		}
+		//Only a valid row counts as the first copy of a school
+		if rowValidator.Valid() {
+			key := data.SchoolKey(school.Name, school.Address)
+			rowValidator.Check(!inFile[key], "school", "appears more than once in the file")
+			inFile[key] = true
+		}
+		if !rowValidator.Valid() {
+			report.Errors = append(report.Errors, importRowError{Row: line, Errors: rowValidator.Errors})
+			continue
+		}
+		schools = append(schools, school)
+		lines = append(lines, line)
+	}
+
+	//Leave out the schools that are already in the directory
+	if len(schools) > 0 {
+		existing, err := app.models.Schools.ExistingKeys(schools)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		var newSchools []*data.School
+		var newLines []int
+		for i, school := range schools {
+			if existing[data.SchoolKey(school.Name, school.Address)] {
+				report.Errors = append(report.Errors, importRowError{Row: lines[i], Errors: map[string]string{"school": "already exists with the same name and address"}})
+				continue
+			}
+			newSchools = append(newSchools, school)
+			newLines = append(newLines, lines[i])
+		}
+		schools, lines = newSchools, newLines
+		sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	}
+	report.Valid = len(schools)
+
+	//Insert the valid rows a chunk at a time
+	if !input.DryRun {
+		for start := 0; start < len(schools); start += input.ChunkSize {
+			end := start + input.ChunkSize
+			if end > len(schools) {
+				end = len(schools)
+			}
+			err = app.models.Schools.InsertMany(schools[start:end])
+			if err != nil {
+				app.logError(r, err)
+				report.ResumeFrom = lines[start]
+				message := fmt.Sprintf("the import stopped at row %d, send the file again with resume_from=%d to continue", lines[start], lines[start])
+				err = app.writeJSON(w, http.StatusInternalServerError, envelope{"error": message, "import": report}, nil)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			report.Inserted += end - start
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setSchoolField() function copies a CSV value into a School field.
+// Modes are separated by semicolons, pipes or commas, empty coordinates are left unset
+func setSchoolField(school *data.School, field, value string) error {
+	switch field {
+	case "name":
+		school.Name = value
+	case "level":
+		school.Level = value
+	case "contact":
+		school.Contact = value
+	case "phone":
+		school.Phone = value
+	case "email":
+		school.Email = value
+	case "website":
+		school.Website = value
+	case "address":
+		school.Address = value
+	case "mode":
+		school.Mode = []string{}
+		for _, mode := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' || r == ',' }) {
+			if mode = strings.TrimSpace(mode); mode != "" {
+				school.Mode = append(school.Mode, mode)
+			}
+		}
+	case "latitude", "longitude":
+		if value == "" {
+			return nil
+		}
+		coordinate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		if field == "latitude" {
+			school.Latitude = &coordinate
+		} else {
+			school.Longitude = &coordinate
+		}
+	}
+	return nil
+}
+
+// The readUpload() method returns an uploaded file, sent either as the named part
+// of a multipart form or as the whole request body. Uploads are limited to 10 MB
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request, name string) (io.ReadCloser, error) {
+	maxBytes := int64(10 << 20)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the upload: %w", err)
+	}
+	file, _, err := r.FormFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("the upload must have a %q file", name)
+	}
+	return file, nil
+}
@@ -0,0 +1,44 @@
+// Filename: kriol/backend/kriol/cmd/api/listener.go
+package main
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"kriol.michaelgomez.net/internal/data"
+)
+
+// The listenForPermissionChanges() method subscribes to permission changes made by
+// any API instance and drops the affected entries from the permission cache
+func (app *application) listenForPermissionChanges() error {
+	cache := app.models.Permissions.Cache
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	err := listener.Listen(data.PermissionsChannel)
+	if err != nil {
+		return err
+	}
+
+	//Start a background Goroutine that runs for the life of the server
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				//A nil notification means the connection was re-established,
+				//changes may have been missed while it was down
+				if n == nil {
+					cache.InvalidateAll()
+					continue
+				}
+				cache.HandleNotification(n.Extra)
+			case <-time.After(90 * time.Second):
+				//Check the connection is still alive when things are quiet
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,110 @@
+//Filename: kriol/backend/kriol/cmd/api/logins.go
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"kriol.michaelgomez.net/internal/data"
+)
+
+// The loginDelay() method returns how much longer a client has to wait before
+// it may try to log in again. The wait doubles with every failure past the threshold
+func (app *application) loginDelay(failures int, lastFailure time.Time) time.Duration {
+	if failures < app.config.login.delayAfter {
+		return 0
+	}
+	//Cap the exponent so the shift cannot overflow
+	exponent := failures - app.config.login.delayAfter
+	if exponent > 16 {
+		exponent = 16
+	}
+	delay := time.Duration(1<<exponent) * time.Second
+	if delay > app.config.login.window {
+		delay = app.config.login.window
+	}
+	return time.Until(lastFailure.Add(delay))
+}
+
+// The checkLoginThrottle() method sends a 429 response and returns false if the
+// email or the IP address has failed to log in too often recently
+func (app *application) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) (*data.LoginFailures, bool) {
+	failures, err := app.models.LoginAttempts.GetFailures(email, app.clientIP(r), time.Now().Add(-app.config.login.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	//Use whichever of the two waits is longer
+	wait := app.loginDelay(failures.ForEmail, failures.LastForEmail)
+	if ipWait := app.loginDelay(failures.ForIP, failures.LastForIP); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return nil, false
+	}
+	return failures, true
+}
+
+// The recordFailedLogin() method stores a failed login and locks the account once
+// it has failed too many times, letting the owner know by email
+func (app *application) recordFailedLogin(r *http.Request, email string, user *data.User, failures *data.LoginFailures) error {
+	err := app.models.LoginAttempts.RecordFailure(email, app.clientIP(r))
+	if err != nil {
+		return err
+	}
+	if user == nil || failures.ForEmail+1 < app.config.login.maxFailures {
+		return nil
+	}
+
+	lockedUntil := time.Now().Add(app.config.login.lockoutDuration)
+	err = app.models.LoginAttempts.Lock(user.ID, lockedUntil)
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("user account locked", map[string]string{
+		"email":      user.Email,
+		"ip_address": app.clientIP(r),
+	})
+
+	ipAddress := app.clientIP(r)
+	app.background(func() {
+		data := map[string]interface{}{
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			"ipAddress":   ipAddress,
+		}
+		//Let the owner know about the lockout
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	return nil
+}
+
+// The unlockUserHandler() lets an admin lift a lockout before it expires
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	//Remove the lock and forget the failures that caused it
+	err := app.models.LoginAttempts.Unlock(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.LoginAttempts.ClearForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"flag"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"kriol.michaelgomez.net/internal/data"
 	"kriol.michaelgomez.net/internal/jsonlog"
 	"kriol.michaelgomez.net/internal/mailer"
+	"kriol.michaelgomez.net/internal/oidc"
 )
 
 // version number
@@ -45,6 +47,31 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	login struct {
+		delayAfter      int           //failures before logins are slowed down
+		maxFailures     int           //failures before the account is locked
+		window          time.Duration //how far back failures are counted
+		lockoutDuration time.Duration //how long an account stays locked
+	}
+	oidc struct {
+		providers []*oidc.Provider
+	}
+	permissions struct {
+		cacheTTL  time.Duration //how long resolved permissions are reused
+		cacheSize int           //how many users are kept in the cache
+	}
+	registration struct {
+		inviteOnly bool //only invited users may register
+	}
+	passwords struct {
+		blocklist string //file of common and breached passwords
+	}
+	reaper struct {
+		interval         time.Duration //how often expired data is purged, 0 turns it off
+		batchSize        int           //rows deleted per statement
+		unactivatedAfter int           //days before unactivated accounts are removed, 0 keeps them
+		schoolRetention  int           //days deleted schools stay in the trash, 0 keeps them
+	}
 }
 
 // dependency injection
@@ -54,6 +81,8 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+	//closed when the server starts shutting down
+	shutdown chan struct{}
 }
 
 func main() {
@@ -85,6 +114,38 @@ func main() {
 		return nil
 	})
 
+	//flags for brute-force protection on login
+	flag.IntVar(&cfg.login.delayAfter, "login-delay-after", 3, "Failed logins before exponential delays start")
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 10, "Failed logins before an account is locked")
+	flag.DurationVar(&cfg.login.window, "login-failure-window", 15*time.Minute, "Window in which failed logins are counted")
+	flag.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", 15*time.Minute, "How long a locked account stays locked")
+
+	//Identity providers for OpenID Connect logins, the flag may be repeated
+	flag.Func("oidc-provider", "OIDC provider as name,issuer,client_id,client_secret,redirect_url (repeatable)", func(val string) error {
+		provider, err := oidc.Parse(val)
+		if err != nil {
+			return err
+		}
+		cfg.oidc.providers = append(cfg.oidc.providers, provider)
+		return nil
+	})
+
+	//flags for the permission cache, a zero value turns it off
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", time.Minute, "How long a user's permissions are cached")
+	flag.IntVar(&cfg.permissions.cacheSize, "permissions-cache-size", 10000, "Maximum number of users with cached permissions")
+
+	//Registration mode
+	flag.BoolVar(&cfg.registration.inviteOnly, "registration-invite-only", false, "Only allow users with an invitation to register")
+
+	//Common and breached passwords new passwords are checked against
+	flag.StringVar(&cfg.passwords.blocklist, "password-blocklist", "", "File of common and breached passwords, one per line, to use instead of the bundled list")
+
+	//flags for the background reaper
+	flag.DurationVar(&cfg.reaper.interval, "reaper-interval", time.Hour, "How often expired tokens and stale accounts are purged (0 disables)")
+	flag.IntVar(&cfg.reaper.batchSize, "reaper-batch-size", 500, "Rows the reaper deletes per batch")
+	flag.IntVar(&cfg.reaper.unactivatedAfter, "reaper-unactivated-days", 0, "Days after which unactivated accounts are removed (0 disables)")
+	flag.IntVar(&cfg.reaper.schoolRetention, "reaper-school-retention-days", 30, "Days deleted schools stay in the trash before they are purged (0 disables)")
+
 	flag.Parse()
 
 	//creating logger
@@ -99,13 +160,37 @@ func main() {
 	defer db.Close()
 	//Log the successful connection pool
 	logger.PrintInfo("database connection pool established", nil)
+
+	//Load the passwords that may not be used, the list built into the binary is used unless another is given
+	blocklist := "bundled"
+	if cfg.passwords.blocklist != "" {
+		_, err := data.LoadCommonPasswords(cfg.passwords.blocklist)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		blocklist = cfg.passwords.blocklist
+	}
+	logger.PrintInfo("password blocklist loaded", map[string]string{
+		"file":      blocklist,
+		"passwords": strconv.Itoa(data.CommonPasswordCount()),
+	})
 	//instance of app struct
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db, data.NewPermissionCache(cfg.permissions.cacheTTL, cfg.permissions.cacheSize)),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		shutdown: make(chan struct{}),
+	}
+	//Keep the permission cache in step with the other API instances
+	if app.models.Permissions.Cache != nil {
+		err = app.listenForPermissionChanges()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 	}
+	//Start purging expired data in the background
+	app.startReaper()
 	//Call app.server() to start the server
 	err = app.serve()
 	if err != nil {
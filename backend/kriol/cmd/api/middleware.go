@@ -93,15 +93,24 @@ func (app *application) authenicate(next http.Handler) http.Handler {
 		//Add a "Vary: Authorization header to the reponse"
 		//A note to caches that no reponse may vary
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 		//Retrieve the value of the Authorization header form the request
 		authorizationHeader := r.Header.Get("Authorization")
+		//Machine clients may send their API key in its own header
+		apiKeyHeader := r.Header.Get("X-API-Key")
 		//if no authorization found then we will created an anonymous user
-		if authorizationHeader == "" {
+		if authorizationHeader == "" && apiKeyHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		//An API key header is never a session token
+		if apiKeyHeader != "" {
+			app.authenticateAPIKey(w, r, next, apiKeyHeader)
+			return
+		}
+
 		//Check if the provided authorization header is in the right format
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
@@ -122,20 +131,58 @@ func (app *application) authenicate(next http.Handler) http.Handler {
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidCredntialsResponse(w, r)
+				//The bearer token might be an API key instead
+				app.authenticateAPIKey(w, r, next, token)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
 			return
 		}
-		//Add the user infromation to the request context
+		//Tokens are revoked when an account is disabled, but never trust one that slipped through
+		if user.Disabled {
+			app.disabledAccountResponse(w, r)
+			return
+		}
+		//Add the user infromation and the token to the request context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		//Call the next handler
 		next.ServeHTTP(w, r)
 	})
 }
 
+// The authenticateAPIKey() method looks up the owner of an API key and
+// stores both the owner and the key in the request context
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, keyPlaintext string) {
+	//Validate the key
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, keyPlaintext); !v.Valid() {
+		app.invalidCredntialsResponse(w, r)
+		return
+	}
+
+	//Retrieve the key and its owner
+	key, user, err := app.models.APIKeys.GetForKey(keyPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredntialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Disabled {
+		app.disabledAccountResponse(w, r)
+		return
+	}
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 // Check for activated user
 func (app *application) requireAuthenitcatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -163,25 +210,40 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 			app.inactiveAccountResponse(w, r)
 			return
 		}
+		//Disabled accounts stay locked out whatever their activation status
+		if user.Disabled {
+			app.disabledAccountResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 
 	return app.requireAuthenitcatedUser(fn)
 }
 
+// Check that the user signed in with a token rather than an API key. Keys and the
+// account itself are only managed from a session, so a leaked key cannot widen its
+// own permissions or take over the account
+func (app *application) requireSessionUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.sessionRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Check for activated user
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//Get the user
-		user := app.contextGetUser(r)
-		//get the permission slice for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		//check for the permissions
+		ok, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		//check for the permissions
-		if !permissions.Include(code) {
+		if !ok {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -190,6 +252,25 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// The hasPermission() method checks if the user on the request holds a permission code
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	//Get the user
+	user := app.contextGetUser(r)
+	//get the permission slice for the user
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+	if !permissions.Include(code) {
+		return false, nil
+	}
+	//API keys are further limited to their own subset of permissions
+	if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+		return false, nil
+	}
+	return true, nil
+}
+
 // Enable CORS
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -210,3 +291,21 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// When the /v1/entries aliases were deprecated and when they will be removed
+var (
+	entriesDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	entriesSunsetAt     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// Mark a request to the deprecated /v1/entries paths. The response carries the
+// Deprecation and Sunset headers and a Link to the same resource under /v1/schools
+func (app *application) deprecatedEntries(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := "/v1/schools" + strings.TrimPrefix(r.URL.Path, "/v1/entries")
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", entriesDeprecatedAt.Unix()))
+		w.Header().Set("Sunset", entriesSunsetAt.Format(http.TimeFormat))
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		next.ServeHTTP(w, r)
+	})
+}
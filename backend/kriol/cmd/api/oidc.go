@@ -0,0 +1,211 @@
+//Filename: kriol/backend/kriol/cmd/api/oidc.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/oidc"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// The oidcProvider() method returns the configured provider named in the URL or nil
+func (app *application) oidcProvider(r *http.Request) *oidc.Provider {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	for _, provider := range app.config.oidc.providers {
+		if provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// The oidcLoginHandler() starts an authorization-code flow with PKCE
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider := app.oidcProvider(r)
+	if provider == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Generate the values that tie the callback to this login
+	state, err := oidc.GenerateState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	nonce, err := oidc.GenerateState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	login := &data.OIDCLogin{
+		State:        state,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Expiry:       time.Now().Add(10 * time.Minute),
+	}
+	err = app.models.Identities.InsertLogin(login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//The client sends the user to this URL
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authorizationURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The oidcCallbackHandler() finishes the flow, finds or creates the user and logs them in
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider := app.oidcProvider(r)
+	if provider == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	if providerError := qs.Get("error"); providerError != "" {
+		app.badRequestResponse(w, r, fmt.Errorf("identity provider returned an error: %s", providerError))
+		return
+	}
+
+	v := validator.New()
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.Identities.TakeLogin(provider.Name, state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Swap the code for the verified ID token claims
+	claims, err := provider.Exchange(r.Context(), code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrTokenRequest):
+			app.invalidCredntialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.findOrCreateOIDCUser(provider.Name, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			v.AddError("email", "the identity provider did not return a verified email address")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errRegistrationClosed):
+			app.errorResponse(w, r, http.StatusForbidden, "registration is by invitation only, ask an administrator for an invitation")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.issueAuthenticationTokens(w, r, user)
+}
+
+var (
+	errUnverifiedEmail    = errors.New("unverified email")
+	errRegistrationClosed = errors.New("registration is invite-only")
+)
+
+// The findOrCreateOIDCUser() method returns the user linked to the provider account.
+// An account that is not linked yet is matched by email, or a new user without a
+// password is created for it
+func (app *application) findOrCreateOIDCUser(provider string, claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	//Only a verified email address may be matched to an account
+	v := validator.New()
+	if data.ValidateEmail(v, claims.Email); !v.Valid() || !claims.Verified() {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		//The provider has verified the address, so the account can be activated
+		if !user.Activated {
+			user.Activated = true
+			err = app.models.Users.Update(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		//Without an invitation nobody new may sign up
+		if app.config.registration.inviteOnly {
+			return nil, errRegistrationClosed
+		}
+		name := strings.TrimSpace(claims.Name)
+		if name == "" || len(name) > 500 {
+			name = strings.Split(claims.Email, "@")[0]
+		}
+		user = &data.User{
+			Name:      name,
+			Email:     claims.Email,
+			Activated: true,
+		}
+		err = app.models.Users.Insert(user)
+		if err != nil {
+			return nil, err
+		}
+		//New users get the same permissions as users who register
+		err = app.models.Permissions.AddForUser(user.ID, "schools:read")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = app.models.Identities.Link(provider, claims.Subject, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
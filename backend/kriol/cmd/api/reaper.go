@@ -0,0 +1,84 @@
+// Filename: kriol/backend/kriol/cmd/api/reaper.go
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// The startReaper() method periodically purges expired tokens and, when configured,
+// accounts that were never activated and schools left in the trash. It stops when the server shuts down
+func (app *application) startReaper() {
+	if app.config.reaper.interval <= 0 || app.config.reaper.batchSize < 1 {
+		return
+	}
+	app.background(func() {
+		ticker := time.NewTicker(app.config.reaper.interval)
+		defer ticker.Stop()
+		for {
+			app.reap()
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// The reap() method runs a single pass of the reaper and logs what it removed
+func (app *application) reap() {
+	tokens, err := app.reapBatches(app.models.Tokens.DeleteExpired)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"task": "expired tokens"})
+	}
+
+	var users int64
+	if days := app.config.reaper.unactivatedAfter; days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		users, err = app.reapBatches(func(batchSize int) (int64, error) {
+			return app.models.Users.DeleteUnactivated(cutoff, batchSize)
+		})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"task": "unactivated users"})
+		}
+	}
+
+	var schools int64
+	if days := app.config.reaper.schoolRetention; days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		schools, err = app.reapBatches(func(batchSize int) (int64, error) {
+			return app.models.Schools.PurgeDeleted(cutoff, batchSize)
+		})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"task": "deleted schools"})
+		}
+	}
+
+	app.logger.PrintInfo("reaper finished", map[string]string{
+		"tokens_removed": strconv.FormatInt(tokens, 10),
+		"users_removed":  strconv.FormatInt(users, 10),
+		"schools_purged": strconv.FormatInt(schools, 10),
+	})
+}
+
+// The reapBatches() method keeps calling deleteBatch until a batch comes back short,
+// so no single statement holds its locks for long. It gives up early on shutdown
+func (app *application) reapBatches(deleteBatch func(batchSize int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		n, err := deleteBatch(app.config.reaper.batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(app.config.reaper.batchSize) {
+			return total, nil
+		}
+		select {
+		case <-app.shutdown:
+			return total, nil
+		default:
+		}
+	}
+}
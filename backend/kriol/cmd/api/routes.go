@@ -15,12 +15,68 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/schools", app.requirePermission("schools:read", app.listSchoolsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/entries", app.requirePermission("schools:write", app.createEntryHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/entries/:id", app.requirePermission("schools:read", app.showEntryHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/entries/:id", app.requirePermission("schools:write", app.updateSchoolHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/entries/:id", app.requirePermission("schools:write", app.deleteSchoolHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/schools", app.requirePermission("schools:write", app.createSchoolHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/schools/:id", app.requirePermission("schools:read", app.showSchoolHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/schools/:id", app.requirePermission("schools:write", app.updateSchoolHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/schools/:id", app.requirePermission("schools:write", app.deleteSchoolHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/schools/:id/restore", app.requirePermission("schools:write", app.restoreSchoolHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exports/schools", app.requirePermission("schools:export", app.exportSchoolsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/imports/schools", app.requirePermission("schools:write", app.importSchoolsHandler))
+	//The old /v1/entries paths are kept for existing clients until they are sunset
+	router.HandlerFunc(http.MethodPost, "/v1/entries", app.deprecatedEntries(app.requirePermission("schools:write", app.createSchoolHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/entries/:id", app.deprecatedEntries(app.requirePermission("schools:read", app.showSchoolHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/entries/:id", app.deprecatedEntries(app.requirePermission("schools:write", app.updateSchoolHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/entries/:id", app.deprecatedEntries(app.requirePermission("schools:write", app.deleteSchoolHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/schools/:id/collaborators", app.requirePermission("schools:write", app.listCollaboratorsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/schools/:id/collaborators", app.requirePermission("schools:write", app.addCollaboratorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/schools/:id/collaborators/:user_id", app.requirePermission("schools:write", app.removeCollaboratorHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activationUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireActivatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.requireSessionUser(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenitcatedUser(app.requireSessionUser(app.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireAuthenitcatedUser(app.requireSessionUser(app.exportCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.requireSessionUser(app.createEmailChangeHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/2fa", app.requireActivatedUser(app.requireSessionUser(app.showTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa", app.requireActivatedUser(app.requireSessionUser(app.createTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/confirm", app.requireActivatedUser(app.requireSessionUser(app.confirmTwoFactorHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/2fa", app.requireActivatedUser(app.requireSessionUser(app.deleteTwoFactorHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.createRefreshTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFATokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/callback", app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tokens", app.requireAuthenitcatedUser(app.requireSessionUser(app.listTokensHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/:id", app.requireAuthenitcatedUser(app.requireSessionUser(app.deleteTokenHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.deleteUserTokensHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/unlock", app.requirePermission("users:admin", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.listUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions/changes", app.requirePermission("users:admin", app.listUserPermissionChangesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.listUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.addUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.removeUserRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/schools/trash", app.requirePermission("schools:admin", app.listTrashHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/invitations", app.requirePermission("users:admin", app.listInvitationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/invitations", app.requirePermission("users:admin", app.createInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/invitations/:id", app.requirePermission("users:admin", app.deleteInvitationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/roles", app.requirePermission("users:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles/:id", app.requirePermission("users:admin", app.showRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/roles/:id", app.requirePermission("users:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.requirePermission("users:admin", app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireActivatedUser(app.requireSessionUser(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireActivatedUser(app.requireSessionUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys/:id", app.requireActivatedUser(app.requireSessionUser(app.showAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireActivatedUser(app.requireSessionUser(app.deleteAPIKeyHandler)))
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenicate(router))))
 }
@@ -0,0 +1,427 @@
+//Filename: kriol/backend/kriol/cmd/api/schools.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+func (app *application) createSchoolHandler(w http.ResponseWriter, r *http.Request) {
+	//Our target decode desitnation
+	var input struct {
+		Name      string   `json:"name"`
+		Level     string   `json:"level"`
+		Contact   string   `json:"contact"`
+		Phone     string   `json:"phone"`
+		Email     string   `json:"email"`
+		Website   string   `json:"website"`
+		Address   string   `json:"address"`
+		Mode      []string `json:"mode"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+
+	//Initialize a new json.Decoder instance
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Copy the valus from the input struct to a new school struct
+	school := &data.School{
+		Name:      input.Name,
+		Level:     input.Level,
+		Contact:   input.Contact,
+		Phone:     input.Phone,
+		Email:     input.Email,
+		Website:   input.Website,
+		Address:   input.Address,
+		Mode:      input.Mode,
+		Latitude:  input.Latitude,
+		Longitude: input.Longitude,
+	}
+	//The user creating the school owns it
+	owner := app.contextGetUser(r)
+	school.OwnerID = &owner.ID
+
+	// Initialize a new Validator Instance
+	v := validator.New()
+
+	//Check the map to determine if there were any validation errors
+	if data.ValidateSchool(v, school); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Create a school
+	err = app.models.Schools.Insert(school)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Create a location header for the newly created resource/School
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/schools/%d", school.ID))
+
+	//Write the JSON response with 201 - Created status code with the body
+	//being the School data and the header being the headers map
+	err = app.writeJSON(w, http.StatusCreated, envelope{"school": school}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSchoolHandler(w http.ResponseWriter, r *http.Request) {
+	//httprouter cannot tell /v1/schools/nearby apart from /v1/schools/:id
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "nearby" {
+		app.nearbySchoolsHandler(w, r)
+		return
+	}
+
+	//getting request data from param function in helpers.go
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Fetch the specitfic school
+	school, err := app.models.Schools.Get(id)
+
+	//Handle errors
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Wrte the data returned by Get()
+	err = app.writeJSON(w, http.StatusOK, envelope{"school": school}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// A nullableFloat tells a field that was sent as null, which is Set with a nil
+// Value, apart from a field that was left out, which is not Set
+type nullableFloat struct {
+	Set   bool
+	Value *float64
+}
+
+func (f *nullableFloat) UnmarshalJSON(b []byte) error {
+	f.Set = true
+	return json.Unmarshal(b, &f.Value)
+}
+
+func (app *application) updateSchoolHandler(w http.ResponseWriter, r *http.Request) {
+	//This method does a parital replacement
+	//Get the id for the school that needs updating
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Fetch the original record from the database
+	school, err := app.models.Schools.Get(id)
+
+	//Handle errors
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Only the owner, a collaborator or a school admin may edit the school
+	ok, err := app.canEditSchool(r, school, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	//Create an input struct to hold data read in from the client
+	//We update the input struct to use pointers because pointers have a default value of nil
+	var input struct {
+		Name      *string       `json:"name"`
+		Level     *string       `json:"level"`
+		Contact   *string       `json:"contact"`
+		Phone     *string       `json:"phone"`
+		Email     *string       `json:"email"`
+		Website   *string       `json:"website"`
+		Address   *string       `json:"address"`
+		Mode      []string      `json:"mode"`
+		Latitude  nullableFloat `json:"latitude"`
+		Longitude nullableFloat `json:"longitude"`
+	}
+
+	//Initialize a new json.Decoder instance
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	//Check for updates
+	if input.Name != nil {
+		school.Name = *input.Name
+	}
+	if input.Level != nil {
+		school.Level = *input.Level
+	}
+	if input.Contact != nil {
+		school.Contact = *input.Contact
+	}
+	if input.Phone != nil {
+		school.Phone = *input.Phone
+	}
+	if input.Email != nil {
+		school.Email = *input.Email
+	}
+	if input.Website != nil {
+		school.Website = *input.Website
+	}
+	if input.Address != nil {
+		school.Address = *input.Address
+	}
+	if input.Mode != nil {
+		school.Mode = input.Mode
+	}
+	//An explicit null clears a coordinate
+	if input.Latitude.Set {
+		school.Latitude = input.Latitude.Value
+	}
+	if input.Longitude.Set {
+		school.Longitude = input.Longitude.Value
+	}
+
+	//Perform validation on the updated School. If validation fails, then we send a 422 - unprocessable enitiy response to the client
+	// Initialize a new Validator Instance
+	v := validator.New()
+
+	//Check the map to determine if there were any validation errors
+	if data.ValidateSchool(v, school); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Pass the updated school record to the update() method
+	err = app.models.Schools.Update(school)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Wrte the data returned by Get()
+	err = app.writeJSON(w, http.StatusOK, envelope{"school": school}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSchoolHandler(w http.ResponseWriter, r *http.Request) {
+	//Get the id for the school that needs updating
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Fetch the school so we can check who owns it
+	school, err := app.models.Schools.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Collaborators may edit a school but only the owner or a school admin may delete it
+	ok, err := app.canEditSchool(r, school, false)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	//Move the School to the trash. Send a 404 Not found status code to the client if there is no mathcing record
+	err = app.models.Schools.Delete(id, app.contextGetUser(r).ID)
+
+	//Handle errors
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Return 200 Status Ok to the client with a success message
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "school successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listSchoolsHandler() allows the client to see a listing of schools based on a set of criteria
+func (app *application) listSchoolsHandler(w http.ResponseWriter, r *http.Request) {
+	//Create an input struct to hold our query parameters
+	var input struct {
+		Name    string
+		Level   string
+		Mode    []string
+		OwnedBy string
+		data.Filters
+	}
+
+	//Initialize a validator
+	v := validator.New()
+	//Get the URL values map
+	qs := r.URL.Query()
+	//Use the helper methods to extract the values
+	input.Name = app.readString(qs, "name", "")
+	input.Level = app.readString(qs, "level", "")
+	input.Mode = app.readCSV(qs, "mode", []string{})
+	input.OwnedBy = app.readString(qs, "owned_by", "")
+	v.Check(validator.In(input.OwnedBy, "", "me"), "owned_by", "must be me")
+	//Get the page information
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//Get the sort information
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	//Specify the allowed sort values
+	input.Filters.SortList = []string{"id", "name", "level", "-id", "-name", "-level"}
+
+	//Checking for validation errors
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Only list the schools of the current user when asked to
+	var ownerID int64
+	if input.OwnedBy == "me" {
+		ownerID = app.contextGetUser(r).ID
+	}
+
+	//Get a listing of all schools
+	schools, metadata, err := app.models.Schools.GetAll(input.Name, input.Level, input.Mode, ownerID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	//Send JSON responce containing all the schools
+	err = app.writeJSON(w, http.StatusOK, envelope{"schools": schools, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// The nearbySchoolsHandler() lists the schools within a radius of a point, closest first
+func (app *application) nearbySchoolsHandler(w http.ResponseWriter, r *http.Request) {
+	//Create an input struct to hold our query parameters
+	var input struct {
+		Latitude  float64
+		Longitude float64
+		RadiusKm  float64
+		Name      string
+		Level     string
+		Mode      []string
+		data.Filters
+	}
+
+	//Initialize a validator
+	v := validator.New()
+	//Get the URL values map
+	qs := r.URL.Query()
+	//The point to search around is required
+	v.Check(qs.Get("lat") != "", "lat", "must be provided")
+	v.Check(qs.Get("lng") != "", "lng", "must be provided")
+	input.Latitude = app.readFloat(qs, "lat", 0, v)
+	input.Longitude = app.readFloat(qs, "lng", 0, v)
+	input.RadiusKm = app.readFloat(qs, "radius_km", 10, v)
+	v.Check(input.Latitude >= -90 && input.Latitude <= 90, "lat", "must be between -90 and 90")
+	v.Check(input.Longitude >= -180 && input.Longitude <= 180, "lng", "must be between -180 and 180")
+	v.Check(input.RadiusKm > 0, "radius_km", "must be greater than zero")
+	v.Check(input.RadiusKm <= 500, "radius_km", "must be a maximum of 500")
+	//Use the helper methods to extract the values
+	input.Name = app.readString(qs, "name", "")
+	input.Level = app.readString(qs, "level", "")
+	input.Mode = app.readCSV(qs, "mode", []string{})
+	//Get the page information
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//Get the sort information, closest first unless asked otherwise
+	input.Filters.Sort = app.readString(qs, "sort", "distance")
+	//Specify the allowed sort values
+	input.Filters.SortList = []string{"distance", "id", "name", "level", "-distance", "-id", "-name", "-level"}
+
+	//Checking for validation errors
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	schools, metadata, err := app.models.Schools.GetNearby(input.Latitude, input.Longitude, input.RadiusKm, input.Name, input.Level, input.Mode, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"schools": schools, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The canEditSchool() method checks if the user on the request may change a school.
+// Owners and holders of schools:admin always may, collaborators only when allowed
+func (app *application) canEditSchool(r *http.Request, school *data.School, allowCollaborators bool) (bool, error) {
+	user := app.contextGetUser(r)
+	if school.OwnerID != nil && *school.OwnerID == user.ID {
+		return true, nil
+	}
+	if allowCollaborators {
+		shared, err := app.models.Schools.IsCollaborator(school.ID, user.ID)
+		if err != nil {
+			return false, err
+		}
+		if shared {
+			return true, nil
+		}
+	}
+	return app.hasPermission(r, "schools:admin")
+}
@@ -46,6 +46,8 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		//Tell long running background tasks to stop
+		close(app.shutdown)
 		//log a message about that goroutines
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
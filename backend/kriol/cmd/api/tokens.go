@@ -5,8 +5,10 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"kriol.michaelgomez.net/internal/data"
 	"kriol.michaelgomez.net/internal/validator"
 )
@@ -32,11 +34,22 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	//Slow down clients that keep failing to log in
+	failures, ok := app.checkLoginThrottle(w, r, input.Email)
+	if !ok {
+		return
+	}
+
 	//Get the user details based on the provided email
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordFailedLogin(r, input.Email, nil, failures)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredntialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -44,6 +57,17 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	//Locked accounts cannot log in until the lock expires
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		app.accountLockedResponse(w, r, lockedUntil)
+		return
+	}
+
 	//Check if the password matches
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
@@ -53,19 +77,305 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	//if passwords don't match, then return an invalid credentials response
 	if !match {
+		err = app.recordFailedLogin(r, input.Email, user, failures)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredntialsResponse(w, r)
 		return
 	}
 
-	//Password is correct, so we will generate a authentication token
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	//A successful login clears the failures for the account
+	err = app.models.LoginAttempts.ClearForEmail(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Upgrade hashes made with an older algorithm or parameters now that we know the password
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(user)
+		}
+		//The login still succeeds, the hash is upgraded on a later login instead
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			app.logger.PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		}
+	}
+
+	//Password is correct, so we will generate the tokens
+	app.issueAuthenticationTokens(w, r, user)
+}
+
+// The issueAuthenticationTokens() method sends a logged in user an authentication
+// token and a refresh token that starts a new token family. Users with 2FA enabled
+// get a short-lived token instead that has to be exchanged together with a code at
+// POST /v1/tokens/mfa
+func (app *application) issueAuthenticationTokens(w http.ResponseWriter, r *http.Request, user *data.User) {
+	//Disabled accounts cannot start new sessions by any login method
+	if user.Disabled {
+		app.disabledAccountResponse(w, r)
+		return
+	}
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		token, err := app.models.Tokens.NewForClient(user.ID, 5*time.Minute, data.ScopeMFAPending, r.UserAgent(), app.clientIP(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_pending_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, refreshToken, err := app.models.Tokens.NewPair(user.ID, 24*time.Hour, 30*24*time.Hour, "", r.UserAgent(), app.clientIP(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	//return the authentifcation toklen to the client
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse the email from the request body
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Validate the email
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//The same response is sent whether or not the email exists so
+	//that this endpoint cannot be used to discover accounts
+	env := envelope{"message": "if an activated account with this email address exists, an email will be sent to you containing password reset instructions"}
+
+	//Get the user details based on the provided email
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Only activated users may reset their password
+	if user != nil && user.Activated && !user.Disabled {
+		//Generate a short-lived password reset token
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+			}
+			//Send the password reset email to the user
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	//Write a 202 accepted status
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAuthenticationTokenHandler() revokes the bearer token used on the request (logout)
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//Delete the token that authenticated this request
+	err := app.models.Tokens.Delete(data.ScopeAuthentication, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listTokensHandler() shows the user their active sessions
+func (app *application) listTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	//Get the authentication tokens of the user
+	tokens, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteTokenHandler() revokes one of the user's sessions by id
+func (app *application) deleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//httprouter does not allow /v1/tokens/authentication and /v1/tokens/:id to
+	//share a method, so the logout route is dispatched from here
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == data.ScopeAuthentication {
+		app.deleteAuthenticationTokenHandler(w, r)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	//Delete the token, a 404 is sent if it does not belong to the user
+	err = app.models.Tokens.DeleteForUser(data.ScopeAuthentication, id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createRefreshTokenHandler() swaps a refresh token for a new access/refresh pair
+func (app *application) createRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse the plaintext refresh token
+	var input struct {
+		TokenPlaintext string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Perform validation
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Rotate the refresh token into a new pair in the same family, reusing an old one revokes the whole family
+	token, refreshToken, err := app.models.Tokens.RotateRefresh(input.TokenPlaintext, 24*time.Hour, 30*24*time.Hour, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredntialsResponse(w, r)
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reuse detected, token family revoked", map[string]string{
+				"ip_address": app.clientIP(r),
+			})
+			app.invalidCredntialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createActivationTokenHandler() resends the welcome email with a fresh activation token
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse the email from the request body
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Validate the email
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//The same response is sent whether or not the email exists so
+	//that this endpoint cannot be used to discover accounts
+	env := envelope{"message": "if an account with this email address needs activating, an email will be sent to you containing activation instructions"}
+
+	//Get the user details based on the provided email
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Only send a new token to users who are not yet activated, disabled accounts get nothing
+	if user != nil && !user.Activated && !user.Disabled {
+		//Remove any old activation tokens
+		err = app.models.Tokens.DeleteAllForUsers(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		//Generate a new activation token
+		token, err := app.models.Tokens.New(user.ID, 1*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			}
+			//Send the email to the user again
+			err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	//Write a 202 accepted status
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
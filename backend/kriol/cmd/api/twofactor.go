@@ -0,0 +1,347 @@
+//Filename: kriol/backend/kriol/cmd/api/twofactor.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"kriol.michaelgomez.net/internal/data"
+	"kriol.michaelgomez.net/internal/totp"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// The name authenticator apps show next to the account
+const totpIssuer = "Appletree"
+
+// The verifyTwoFactorCode() method accepts either a TOTP code or an unused recovery code
+func (app *application) verifyTwoFactorCode(twoFactor *data.TwoFactor, code string) (bool, error) {
+	//Try the code as a TOTP code first
+	step, ok := totp.Validate(code, twoFactor.Secret, time.Now())
+	if ok {
+		err := app.models.TwoFactor.UseStep(twoFactor.UserID, step)
+		if err != nil {
+			switch {
+			//The code was already used
+			case errors.Is(err, data.ErrEditConflict):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	//Otherwise it may be a recovery code
+	return app.models.TwoFactor.UseRecoveryCode(twoFactor.UserID, code)
+}
+
+// The checkPassword() method adds a validation error if the password is wrong
+func (app *application) checkPassword(v *validator.Validator, user *data.User, plaintext string) error {
+	if plaintext == "" {
+		v.AddError("password", "must be provided")
+		return nil
+	}
+	match, err := user.Password.Matches(plaintext)
+	if err != nil {
+		return err
+	}
+	v.Check(match, "password", "is incorrect")
+	return nil
+}
+
+func (app *application) showTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	//Users who never enrolled simply have 2FA disabled
+	status := envelope{"enabled": false}
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		remaining, err := app.models.TwoFactor.CountRecoveryCodes(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		status = envelope{"enabled": true, "recovery_codes_remaining": remaining}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"two_factor": status}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createTwoFactorHandler() starts an enrollment and hands out the secret
+func (app *application) createTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	//The current password is needed to change 2FA settings
+	v := validator.New()
+	err = app.checkPassword(v, user, input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TwoFactor.Enroll(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("two_factor", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"two_factor": envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmTwoFactorHandler() enables 2FA once the user proves their app works
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor", "enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if twoFactor.Enabled {
+		v.AddError("two_factor", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Recovery codes do not exist yet so only a TOTP code is accepted
+	step, ok := totp.Validate(input.Code, twoFactor.Secret, time.Now())
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	//Turn 2FA on and create the recovery codes together
+	codes, err := app.models.TwoFactor.Confirm(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("code", "invalid or expired code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//The recovery codes are only ever shown in this response
+	env := envelope{"two_factor": envelope{"enabled": true, "recovery_codes": codes}}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteTwoFactorHandler() turns 2FA off
+func (app *application) deleteTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	err = app.checkPassword(v, user, input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//An enabled 2FA needs a code to be turned off, a pending enrollment does not
+	if twoFactor.Enabled {
+		ok, err := app.verifyTwoFactorCode(twoFactor, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			v.AddError("code", "invalid or expired code")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	err = app.models.TwoFactor.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createMFATokenHandler() swaps an mfa_pending token and a code for an authentication token
+func (app *application) createMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"mfa_pending_token"`
+		Code           string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFAPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredntialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Wrong codes count towards the same limits as wrong passwords
+	failures, ok := app.checkLoginThrottle(w, r, user.Email)
+	if !ok {
+		return
+	}
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		app.accountLockedResponse(w, r, lockedUntil)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredntialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	ok, err = app.verifyTwoFactorCode(twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordFailedLogin(r, user.Email, user, failures)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredntialsResponse(w, r)
+		return
+	}
+
+	//The pending token can only be exchanged once, a concurrent request that
+	//already took it gets nothing
+	err = app.models.Tokens.Take(data.ScopeMFAPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredntialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.LoginAttempts.ClearForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.models.Tokens.NewPair(user.ID, 24*time.Hour, 30*24*time.Hour, "", r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
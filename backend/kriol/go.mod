@@ -1,6 +1,6 @@
 module kriol.michaelgomez.net
 
-go 1.19
+go 1.20
 
 require github.com/julienschmidt/httprouter v1.3.0
 
@@ -13,4 +13,7 @@ require (
 	gopkg.in/mail.v2 v2.3.1
 )
 
-require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+require (
+	golang.org/x/sys v0.2.0 // indirect
+	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+)
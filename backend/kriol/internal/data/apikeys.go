@@ -0,0 +1,255 @@
+// Filename: kriol/backend/kriol/internal/data/apikeys.go
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// API keys live in the tokens table under their own scope
+const ScopeAPIKey = "api-key"
+
+// Define the APIKey type
+type APIKey struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// Validate an API key against the permissions of its owner
+func ValidateAPIKey(v *validator.Validator, key *APIKey, ownerPermissions Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 entry")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate entries")
+	for _, code := range key.Permissions {
+		v.Check(ownerPermissions.Include(code), "permissions", "must be a subset of your own permissions")
+	}
+}
+
+// Define the APIKey model
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Create and insert a new API key along with its permissions
+func (m APIKeyModel) Insert(key *APIKey) error {
+	plaintext, err := generateRandomString()
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(plaintext))
+	key.Plaintext = plaintext
+	key.Hash = hash[:]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		insert into tokens (hash, user_id, expiry, scope, name)
+		values ($1, $2, $3, $4, $5)
+		returning id, created_at
+	`
+	args := []interface{}{key.Hash, key.UserID, key.Expiry, ScopeAPIKey, key.Name}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		insert into tokens_permissions
+		select $1, permissions.id from permissions where permissions.code = any($2)
+	`
+	_, err = tx.ExecContext(ctx, query, key.ID, pq.Array([]string(key.Permissions)))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get a specific API key that belongs to the user
+func (m APIKeyModel) Get(id, userID int64) (*APIKey, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		select tokens.id, tokens.user_id, tokens.name, tokens.created_at, tokens.expiry, tokens.last_used_at,
+		array_remove(array_agg(permissions.code), null)
+		from tokens
+		left join tokens_permissions on tokens_permissions.token_id = tokens.id
+		left join permissions on permissions.id = tokens_permissions.permission_id
+		where tokens.scope = $1 and tokens.id = $2 and tokens.user_id = $3
+		group by tokens.id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := m.DB.QueryRowContext(ctx, query, ScopeAPIKey, id, userID).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsedAt,
+		pq.Array((*[]string)(&key.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// Get all the API keys of a user, expired keys included
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		select tokens.id, tokens.user_id, tokens.name, tokens.created_at, tokens.expiry, tokens.last_used_at,
+		array_remove(array_agg(permissions.code), null)
+		from tokens
+		left join tokens_permissions on tokens_permissions.token_id = tokens.id
+		left join permissions on permissions.id = tokens_permissions.permission_id
+		where tokens.scope = $1 and tokens.user_id = $2
+		group by tokens.id
+		order by tokens.created_at desc, tokens.id desc
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, ScopeAPIKey, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.CreatedAt,
+			&key.Expiry,
+			&key.LastUsedAt,
+			pq.Array((*[]string)(&key.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete an API key that belongs to the user
+func (m APIKeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		delete from tokens
+		where scope = $1 and id = $2 and user_id = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, ScopeAPIKey, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Get the key and its owner for a plaintext API key and record that the key was used
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, *User, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+	query := `
+		with key as (
+			update tokens
+			set last_used_at = now()
+			where hash = $1 and scope = $2 and (expiry is null or expiry > $3)
+			returning id, user_id, name, created_at, expiry, last_used_at
+		)
+		select key.id, key.user_id, key.name, key.created_at, key.expiry, key.last_used_at,
+		array(
+			select permissions.code
+			from permissions
+			inner join tokens_permissions on tokens_permissions.permission_id = permissions.id
+			where tokens_permissions.token_id = key.id
+		),
+		users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version
+		from key
+		inner join users on users.id = key.user_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], ScopeAPIKey, time.Now()).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsedAt,
+		pq.Array((*[]string)(&key.Permissions)),
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return &key, &user, nil
+}
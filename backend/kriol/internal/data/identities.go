@@ -0,0 +1,161 @@
+// Filename: kriol/backend/kriol/internal/data/identities.go
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCLogin holds what we need to finish a login once the provider redirects back
+type OIDCLogin struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+// Identity is an account at an identity provider that is linked to a user
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Define the Identity model
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Remember a login that was started with a provider
+func (m IdentityModel) InsertLogin(login *OIDCLogin) error {
+	stateHash := sha256.Sum256([]byte(login.State))
+	query := `
+		insert into oidc_logins (state_hash, provider, nonce, code_verifier, expiry)
+		values ($1, $2, $3, $4, $5)
+	`
+	args := []interface{}{stateHash[:], login.Provider, login.Nonce, login.CodeVerifier, login.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Take a pending login by its state, a state can only be used once
+func (m IdentityModel) TakeLogin(provider, state string) (*OIDCLogin, error) {
+	stateHash := sha256.Sum256([]byte(state))
+	query := `
+		delete from oidc_logins
+		where state_hash = $1 and provider = $2
+		returning provider, nonce, code_verifier, expiry
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	login := OIDCLogin{State: state}
+	err := m.DB.QueryRowContext(ctx, query, stateHash[:], provider).Scan(
+		&login.Provider,
+		&login.Nonce,
+		&login.CodeVerifier,
+		&login.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if time.Now().After(login.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+	return &login, nil
+}
+
+// Get the user linked to an account at a provider
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		select users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version
+		from users
+		inner join users_identities
+		on users.id = users_identities.user_id
+		where users_identities.provider = $1
+		and users_identities.subject = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Link an account at a provider to a user
+func (m IdentityModel) Link(provider, subject string, userID int64) error {
+	query := `
+		insert into users_identities (provider, subject, user_id)
+		values ($1, $2, $3)
+		on conflict (provider, subject) do nothing
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, provider, subject, userID)
+	return err
+}
+
+// Get the provider accounts linked to a user
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+		select provider, subject, created_at
+		from users_identities
+		where user_id = $1
+		order by created_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.Provider, &identity.Subject, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
@@ -0,0 +1,246 @@
+// Filename: kriol/backend/kriol/internal/data/invitations.go
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+var (
+	ErrInvitationEmail = errors.New("invitation is for another email address")
+)
+
+// An Invitation lets someone register while registration is invite-only
+type Invitation struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"-"`
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"`
+	InvitedBy   *int64      `json:"invited_by"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      time.Time   `json:"expiry"`
+	AcceptedAt  *time.Time  `json:"accepted_at"`
+	UserID      *int64      `json:"user_id"`
+}
+
+// Validate an invitation against the permission codes that exist
+func ValidateInvitation(v *validator.Validator, invitation *Invitation, knownPermissions Permissions) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate entries")
+	for _, code := range invitation.Permissions {
+		v.Check(knownPermissions.Include(code), "permissions", "must only contain known permission codes")
+	}
+}
+
+// Define the Invitation model
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates an invitation that is valid for ttl, the plaintext token is set on it
+func (m InvitationModel) Insert(invitation *Invitation, ttl time.Duration) error {
+	plaintext, err := generateRandomString()
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(plaintext))
+	invitation.Plaintext = plaintext
+	invitation.Expiry = time.Now().Add(ttl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		insert into invitations (hash, email, invited_by, expiry)
+		values ($1, $2, $3, $4)
+		returning id, created_at
+	`
+	args := []interface{}{hash[:], invitation.Email, invitation.InvitedBy, invitation.Expiry}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		insert into invitations_permissions
+		select $1, permissions.id from permissions where permissions.code = any($2)
+	`
+	_, err = tx.ExecContext(ctx, query, invitation.ID, pq.Array([]string(invitation.Permissions)))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAll() returns every invitation, newest first
+func (m InvitationModel) GetAll() ([]*Invitation, error) {
+	query := `
+		select invitations.id, invitations.email, invitations.invited_by, invitations.created_at,
+		invitations.expiry, invitations.accepted_at, invitations.user_id,
+		array(
+			select permissions.code
+			from permissions
+			inner join invitations_permissions on invitations_permissions.permission_id = permissions.id
+			where invitations_permissions.invitation_id = invitations.id
+			order by permissions.code
+		)
+		from invitations
+		order by invitations.created_at desc, invitations.id desc
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.Email,
+			&invitation.InvitedBy,
+			&invitation.CreatedAt,
+			&invitation.Expiry,
+			&invitation.AcceptedAt,
+			&invitation.UserID,
+			pq.Array((*[]string)(&invitation.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// Delete() withdraws an invitation
+func (m InvitationModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		delete from invitations
+		where id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Redeem() creates the user an invitation was sent to, gives them its permissions
+// and uses the invitation up, all in one transaction so an invitation is only used once.
+// ErrRecordNotFound means the token is invalid, expired or already used
+func (m InvitationModel) Redeem(plaintext string, user *User) (*Invitation, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		select id, email, invited_by, created_at, expiry
+		from invitations
+		where hash = $1 and accepted_at is null and expiry > now()
+		for update
+	`
+	var invitation Invitation
+	err = tx.QueryRowContext(ctx, query, hash[:]).Scan(
+		&invitation.ID,
+		&invitation.Email,
+		&invitation.InvitedBy,
+		&invitation.CreatedAt,
+		&invitation.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, ErrInvitationEmail
+	}
+
+	query = `
+		insert into users (name, email, password_hash, activated, activated_at)
+		values ($1, $2, $3, $4, case when $4 then now() end)
+		returning id, created_at, version
+	`
+	args := []interface{}{user.Name, user.Email, user.Password.value(), user.Activated}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return nil, ErrDuplicateEmail
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		insert into users_permissions
+		select $1, permission_id from invitations_permissions where invitation_id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, user.ID, invitation.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		update invitations
+		set accepted_at = now(), user_id = $1
+		where id = $2
+		returning accepted_at
+	`
+	err = tx.QueryRowContext(ctx, query, user.ID, invitation.ID).Scan(&invitation.AcceptedAt)
+	if err != nil {
+		return nil, err
+	}
+	invitation.UserID = &user.ID
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
@@ -0,0 +1,128 @@
+// Filename: kriol/backend/kriol/internal/data/logins.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailures summarises the recent failed logins for an email and an IP address
+type LoginFailures struct {
+	ForEmail     int
+	ForIP        int
+	LastForEmail time.Time
+	LastForIP    time.Time
+}
+
+// Define the LoginAttempt model
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Record a failed login
+func (m LoginAttemptModel) RecordFailure(email, ipAddress string) error {
+	query := `
+		insert into login_failures (email, ip_address)
+		values ($1, $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, email, ipAddress)
+	return err
+}
+
+// Count the failed logins for the email and for the IP address since a point in time
+func (m LoginAttemptModel) GetFailures(email, ipAddress string, since time.Time) (*LoginFailures, error) {
+	query := `
+		select count(*) filter (where email = $1),
+		count(*) filter (where ip_address = $2),
+		coalesce(max(created_at) filter (where email = $1), 'epoch'),
+		coalesce(max(created_at) filter (where ip_address = $2), 'epoch')
+		from login_failures
+		where (email = $1 or ip_address = $2)
+		and created_at > $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures LoginFailures
+	err := m.DB.QueryRowContext(ctx, query, email, ipAddress, since).Scan(
+		&failures.ForEmail,
+		&failures.ForIP,
+		&failures.LastForEmail,
+		&failures.LastForIP,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &failures, nil
+}
+
+// Forget the failed logins for an email
+func (m LoginAttemptModel) ClearForEmail(email string) error {
+	query := `
+		delete from login_failures
+		where email = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
+
+// Lock an account until the given time
+func (m LoginAttemptModel) Lock(userID int64, until time.Time) error {
+	query := `
+		insert into account_lockouts (user_id, locked_until)
+		values ($1, $2)
+		on conflict (user_id) do update
+		set locked_until = excluded.locked_until, created_at = now()
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, until)
+	return err
+}
+
+// Get the time an account is locked until, the zero time means it is not locked
+func (m LoginAttemptModel) LockedUntil(userID int64) (time.Time, error) {
+	query := `
+		select locked_until
+		from account_lockouts
+		where user_id = $1 and locked_until > $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lockedUntil time.Time
+	err := m.DB.QueryRowContext(ctx, query, userID, time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, nil
+		default:
+			return time.Time{}, err
+		}
+	}
+	return lockedUntil, nil
+}
+
+// Unlock an account
+func (m LoginAttemptModel) Unlock(userID int64) error {
+	query := `
+		delete from account_lockouts
+		where user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
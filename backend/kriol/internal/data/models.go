@@ -13,18 +13,31 @@ var (
 
 // A wrapper for our data models
 type Models struct {
-	Schools     SchoolModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Schools       SchoolModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	APIKeys       APIKeyModel
+	LoginAttempts LoginAttemptModel
+	TwoFactor     TwoFactorModel
+	Identities    IdentityModel
+	Roles         RoleModel
+	Invitations   InvitationModel
 }
 
-// NewModels() allows us to create a new model
-func NewModels(db *sql.DB) Models {
+// NewModels() allows us to create a new model, the permission and role models
+// share the permission cache so role changes can invalidate it
+func NewModels(db *sql.DB, permissionCache *PermissionCache) Models {
 	return Models{
-		Schools:     SchoolModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Schools:       SchoolModel{DB: db},
+		Users:         UserModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db, Cache: permissionCache},
+		APIKeys:       APIKeyModel{DB: db},
+		LoginAttempts: LoginAttemptModel{DB: db},
+		TwoFactor:     TwoFactorModel{DB: db},
+		Identities:    IdentityModel{DB: db},
+		Roles:         RoleModel{DB: db, Cache: permissionCache},
+		Invitations:   InvitationModel{DB: db},
 	}
 }
@@ -0,0 +1,160 @@
+// Filename: internal/data/passwords.go
+
+package data
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+// The estimated entropy, in bits, a new password needs
+const MinPasswordEntropy = 45
+
+//go:embed "passwords/common.txt"
+var bundledPasswords string
+
+// commonPasswords holds the lowercased common and breached passwords. It starts
+// out with the bundled list, LoadCommonPasswords() can replace it at startup
+var commonPasswords, _ = parseCommonPasswords(strings.NewReader(bundledPasswords))
+
+// LoadCommonPasswords() reads a list of common and breached passwords, one per line,
+// to use instead of the bundled one and returns how many were loaded
+func LoadCommonPasswords(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	passwords, err := parseCommonPasswords(file)
+	if err != nil {
+		return 0, err
+	}
+	commonPasswords = passwords
+	return len(passwords), nil
+}
+
+// CommonPasswordCount() reports how many passwords are in the list in use
+func CommonPasswordCount() int {
+	return len(commonPasswords)
+}
+
+// parseCommonPasswords() reads one password per line. Blank lines and lines
+// starting with # are skipped
+func parseCommonPasswords(r io.Reader) (map[string]struct{}, error) {
+	passwords := map[string]struct{}{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return passwords, nil
+}
+
+// isCommonPassword() checks the list, also ignoring the digits and symbols people
+// tend to tack onto the end of a common password, as in "password123!"
+func isCommonPassword(password string) bool {
+	password = strings.ToLower(password)
+	if _, ok := commonPasswords[password]; ok {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(password, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	if trimmed == "" || trimmed == password {
+		return false
+	}
+	_, ok := commonPasswords[trimmed]
+	return ok
+}
+
+// passwordEntropy() estimates the entropy of a password in bits. Each character
+// is worth log2 of the size of the character classes used, but repeated characters
+// and runs such as "abc" or "321" are worth very little
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSymbol(r) || r == ' '):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	entropy := 0.0
+	var prev rune
+	for i, r := range []rune(password) {
+		switch {
+		case i > 0 && r == prev:
+			entropy += 1
+		case i > 0 && (r == prev+1 || r == prev-1):
+			entropy += 2
+		default:
+			entropy += perChar
+		}
+		prev = r
+	}
+	return entropy
+}
+
+// ValidatePasswordStrength() rejects new passwords that are common, contain
+// something about the user such as their name or email, or are easy to guess
+func ValidatePasswordStrength(v *validator.Validator, password string, userInputs ...string) {
+	if password == "" {
+		return
+	}
+	v.Check(!isCommonPassword(password), "password", "is too common, it appears in lists of breached passwords")
+
+	lowered := strings.ToLower(password)
+	for _, input := range userInputs {
+		//Check the name and the part of the email before the @
+		input = strings.ToLower(strings.SplitN(input, "@", 2)[0])
+		for _, word := range strings.Fields(input) {
+			if len(word) >= 3 && strings.Contains(lowered, word) {
+				v.AddError("password", "must not contain your name or email address")
+			}
+		}
+	}
+
+	v.Check(passwordEntropy(password) >= MinPasswordEntropy, "password", "is too easy to guess, use a longer password or mix in upper case letters, digits and symbols")
+}
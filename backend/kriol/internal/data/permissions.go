@@ -12,7 +12,8 @@ import (
 // Define a slice to hold the permissions codes
 type Permissions []string
 
-// Checks the slice for a specific permission code
+// Checks the slice for a specific permission code. The slice returned by
+// GetAllForUser() already holds the codes granted through roles
 func (p Permissions) Include(code string) bool {
 	for i := range p {
 		if code == p[i] {
@@ -23,18 +24,41 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *PermissionCache
 }
 
+// GetAllForUser() returns the permissions granted to a user directly and through
+// their roles, including the roles those roles inherit from. Results are served
+// from the cache when possible
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	permissions, generation, ok := m.Cache.get(userID)
+	if ok {
+		return permissions, nil
+	}
+
 	query := `
-		select permissions.code 
+		with recursive user_roles(id) as (
+			select role_id from users_roles where user_id = $1
+			union
+			select roles_parents.parent_id
+			from roles_parents
+			inner join user_roles on roles_parents.role_id = user_roles.id
+		)
+		select permissions.code
 		from permissions
 		inner join users_permissions
 		on users_permissions.permission_id = permissions.id
 		inner join users
-		on users_permissions.id = users.id
+		on users_permissions.user_id = users.id
 		where users.id = $1
+		union
+		select permissions.code
+		from permissions
+		inner join roles_permissions
+		on roles_permissions.permission_id = permissions.id
+		inner join user_roles
+		on roles_permissions.role_id = user_roles.id
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -45,7 +69,6 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	}
 	defer rows.Close()
 
-	var permissions Permissions
 	for rows.Next() {
 		var permisison string
 		err := rows.Scan(&permisison)
@@ -57,6 +80,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	m.Cache.set(userID, permissions, generation)
 	return permissions, nil
 }
 
@@ -70,5 +94,141 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	if err != nil {
+		return err
+	}
+	return permissionsChanged(ctx, m.DB, m.Cache, userID)
+}
+
+// A PermissionChange is an entry in the permissions audit log. Role changes are
+// logged as grant_role and revoke_role with the role name as the code
+type PermissionChange struct {
+	ID        int64     `json:"id"`
+	Code      string    `json:"code"`
+	Action    string    `json:"action"`
+	ActorID   *int64    `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Get every permission code that exists
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+		select code
+		from permissions
+		order by code
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// Grant permissions to a user and record who granted them. Permissions the
+// user already has are skipped and not recorded
+func (m PermissionModel) Grant(userID, actorID int64, codes ...string) error {
+	query := `
+		with granted as (
+			insert into users_permissions
+			select $1, permissions.id from permissions where permissions.code = any($2)
+			on conflict do nothing
+			returning permission_id
+		)
+		insert into permissions_audit (user_id, code, action, actor_id)
+		select $1, permissions.code, 'grant', $3
+		from granted
+		inner join permissions on permissions.id = granted.permission_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes), actorID)
+	if err != nil {
+		return err
+	}
+	return permissionsChanged(ctx, m.DB, m.Cache, userID)
+}
+
+// Revoke a permission from a user and record who revoked it
+func (m PermissionModel) Revoke(userID, actorID int64, code string) error {
+	query := `
+		with revoked as (
+			delete from users_permissions
+			using permissions
+			where users_permissions.permission_id = permissions.id
+			and users_permissions.user_id = $1
+			and permissions.code = $2
+			returning permissions.code
+		)
+		insert into permissions_audit (user_id, code, action, actor_id)
+		select $1, revoked.code, 'revoke', $3
+		from revoked
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, code, actorID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	//The user did not have the permission
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return permissionsChanged(ctx, m.DB, m.Cache, userID)
+}
+
+// Get the audit log of permission changes for a user, newest first
+func (m PermissionModel) GetChangesForUser(userID int64) ([]*PermissionChange, error) {
+	query := `
+		select id, code, action, actor_id, created_at
+		from permissions_audit
+		where user_id = $1
+		order by created_at desc, id desc
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*PermissionChange{}
+	for rows.Next() {
+		var change PermissionChange
+		err := rows.Scan(&change.ID, &change.Code, &change.Action, &change.ActorID, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
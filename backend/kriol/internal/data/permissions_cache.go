@@ -0,0 +1,161 @@
+// Filename: internal/data/permissions_cache.go
+
+package data
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PermissionsChannel is the Postgres channel that carries permission changes
+// between API instances. The payload is a user id, or "*" for every user
+const PermissionsChannel = "permissions_changed"
+
+// A PermissionCache holds the resolved permissions of recently seen users.
+// A nil cache is valid and caches nothing
+type PermissionCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	size       int
+	entries    map[int64]*list.Element
+	order      *list.List //most recently used at the front
+	generation uint64     //bumped by every invalidation
+}
+
+type permissionCacheEntry struct {
+	userID      int64
+	permissions Permissions
+	expiry      time.Time
+}
+
+// NewPermissionCache() returns a cache holding at most size users for ttl.
+// A zero ttl or size turns caching off
+func NewPermissionCache(ttl time.Duration, size int) *PermissionCache {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+	return &PermissionCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[int64]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get() returns the cached permissions of a user and the current generation,
+// which must be handed back to set()
+func (c *PermissionCache) get(userID int64) (Permissions, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[userID]
+	if !ok {
+		return nil, c.generation, false
+	}
+	entry := element.Value.(*permissionCacheEntry)
+	if time.Now().After(entry.expiry) {
+		c.order.Remove(element)
+		delete(c.entries, userID)
+		return nil, c.generation, false
+	}
+	c.order.MoveToFront(element)
+	return entry.permissions, c.generation, true
+}
+
+// set() stores the permissions of a user. It does nothing when an invalidation
+// happened since get(), as the permissions may already be stale
+func (c *PermissionCache) set(userID int64, permissions Permissions, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	entry := &permissionCacheEntry{userID: userID, permissions: permissions, expiry: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[userID]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[userID] = c.order.PushFront(entry)
+	//Drop the least recently used user when the cache is full
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*permissionCacheEntry).userID)
+	}
+}
+
+// Invalidate() drops the cached permissions of a user
+func (c *PermissionCache) Invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if element, ok := c.entries[userID]; ok {
+		c.order.Remove(element)
+		delete(c.entries, userID)
+	}
+}
+
+// InvalidateAll() empties the cache
+func (c *PermissionCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[int64]*list.Element)
+	c.order.Init()
+}
+
+// HandleNotification() applies a payload received on PermissionsChannel
+func (c *PermissionCache) HandleNotification(payload string) {
+	userID, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		c.InvalidateAll()
+		return
+	}
+	c.Invalidate(userID)
+}
+
+// permissionsChanged() invalidates the local cache and tells the other API
+// instances to do the same. A userID of 0 means every user may be affected
+func permissionsChanged(ctx context.Context, db *sql.DB, cache *PermissionCache, userID int64) error {
+	if userID == 0 {
+		cache.InvalidateAll()
+	} else {
+		cache.Invalidate(userID)
+	}
+	return notifyPermissionsChanged(ctx, db, userID)
+}
+
+// notifyPermissionsChanged() sends the notification on PermissionsChannel. Sent
+// inside a transaction it is only delivered once the transaction commits, so a
+// change made in a transaction should notify through it and invalidate the local
+// cache after the commit
+func notifyPermissionsChanged(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}, userID int64) error {
+	payload := "*"
+	if userID != 0 {
+		payload = strconv.FormatInt(userID, 10)
+	}
+	_, err := db.ExecContext(ctx, `select pg_notify($1, $2)`, PermissionsChannel, payload)
+	return err
+}
@@ -0,0 +1,420 @@
+// Filename: kriol/backend/kriol/internal/data/roles.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"kriol.michaelgomez.net/internal/validator"
+)
+
+var (
+	ErrDuplicateRoleName = errors.New("duplicate role name")
+	ErrRoleCycle         = errors.New("role inheritance cycle")
+)
+
+// A Role groups permission codes and inherits the codes of its parents
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+	Parents     []string    `json:"parents"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Version     int32       `json:"version"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role, knownPermissions Permissions) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 bytes long")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate entries")
+	for _, code := range role.Permissions {
+		v.Check(knownPermissions.Include(code), "permissions", "must only contain known permission codes")
+	}
+
+	v.Check(validator.Unique(role.Parents), "parents", "must not contain duplicate entries")
+	v.Check(!validator.In(role.Name, role.Parents...), "parents", "must not contain the role itself")
+}
+
+// Define the Role model
+type RoleModel struct {
+	DB    *sql.DB
+	Cache *PermissionCache
+}
+
+// The query that reads a role along with its permission codes and parent names
+const roleQuery = `
+	select roles.id, roles.name, roles.description, roles.created_at, roles.version,
+	array(
+		select permissions.code
+		from permissions
+		inner join roles_permissions on roles_permissions.permission_id = permissions.id
+		where roles_permissions.role_id = roles.id
+		order by permissions.code
+	),
+	array(
+		select parents.name
+		from roles parents
+		inner join roles_parents on roles_parents.parent_id = parents.id
+		where roles_parents.role_id = roles.id
+		order by parents.name
+	)
+	from roles
+`
+
+func scanRole(row interface{ Scan(...interface{}) error }) (*Role, error) {
+	var role Role
+	err := row.Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.CreatedAt,
+		&role.Version,
+		pq.Array((*[]string)(&role.Permissions)),
+		pq.Array(&role.Parents),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// Insert() creates a role with its permissions and parents
+func (m RoleModel) Insert(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		insert into roles (name, description)
+		values ($1, $2)
+		returning id, created_at, version
+	`
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt, &role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	err = setRoleGrants(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get() returns a specific role
+func (m RoleModel) Get(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	role, err := scanRole(m.DB.QueryRowContext(ctx, roleQuery+` where roles.id = $1`, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return role, nil
+}
+
+// GetAll() returns every role sorted by name
+func (m RoleModel) GetAll() ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, roleQuery+` order by roles.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Update() changes a role and replaces its permissions and parents
+// Optimistic locking (version number)
+func (m RoleModel) Update(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		update roles
+		set name = $1, description = $2, version = version + 1
+		where id = $3 and version = $4
+		returning version
+	`
+	args := []interface{}{role.Name, role.Description, role.ID, role.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = setRoleGrants(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	//Every user holding the role or a role inheriting from it is affected
+	return permissionsChanged(ctx, m.DB, m.Cache, 0)
+}
+
+// The setRoleGrants() function replaces the permissions and parents of a role
+// and makes sure the role does not end up inheriting from itself
+func setRoleGrants(ctx context.Context, tx *sql.Tx, role *Role) error {
+	_, err := tx.ExecContext(ctx, `delete from roles_permissions where role_id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+	query := `
+		insert into roles_permissions
+		select $1, permissions.id from permissions where permissions.code = any($2)
+	`
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array([]string(role.Permissions)))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from roles_parents where role_id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+	query = `
+		insert into roles_parents
+		select $1, roles.id from roles where roles.name = any($2)
+	`
+	result, err := tx.ExecContext(ctx, query, role.ID, pq.Array(role.Parents))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(rowsAffected) != len(role.Parents) {
+		return ErrRecordNotFound
+	}
+
+	//Walk up the parents, union stops at rows it has already seen
+	query = `
+		with recursive ancestors(id) as (
+			select parent_id from roles_parents where role_id = $1
+			union
+			select roles_parents.parent_id
+			from roles_parents
+			inner join ancestors on roles_parents.role_id = ancestors.id
+		)
+		select exists(select 1 from ancestors where id = $1)
+	`
+	var cycle bool
+	err = tx.QueryRowContext(ctx, query, role.ID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrRoleCycle
+	}
+	return nil
+}
+
+// Delete() removes a role, users lose the permissions it granted
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		delete from roles
+		where id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return permissionsChanged(ctx, m.DB, m.Cache, 0)
+}
+
+// GetAllForUser() returns the names of the roles given to a user
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+		select roles.name
+		from roles
+		inner join users_roles on users_roles.role_id = roles.id
+		where users_roles.user_id = $1
+		order by roles.name
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// AddForUser() gives roles to a user and records who gave them. Roles the user
+// already has are skipped and not recorded. ErrRecordNotFound means a role does not exist
+func (m RoleModel) AddForUser(userID, actorID int64, names ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRowContext(ctx, `select count(*) from roles where name = any($1)`, pq.Array(names)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(names) {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		with added as (
+			insert into users_roles
+			select $1, roles.id from roles where roles.name = any($2)
+			on conflict do nothing
+			returning role_id
+		)
+		insert into permissions_audit (user_id, code, action, actor_id)
+		select $1, roles.name, 'grant_role', $3
+		from added
+		inner join roles on roles.id = added.role_id
+	`
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(names), actorID)
+	if err != nil {
+		return err
+	}
+	err = notifyPermissionsChanged(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// RemoveForUser() takes a role away from a user and records who took it
+func (m RoleModel) RemoveForUser(userID, actorID int64, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		with removed as (
+			delete from users_roles
+			using roles
+			where users_roles.role_id = roles.id
+			and users_roles.user_id = $1
+			and roles.name = $2
+			returning roles.name
+		)
+		insert into permissions_audit (user_id, code, action, actor_id)
+		select $1, removed.name, 'revoke_role', $3
+		from removed
+	`
+	result, err := tx.ExecContext(ctx, query, userID, name, actorID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	//The user did not have the role
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	err = notifyPermissionsChanged(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.Cache.Invalidate(userID)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -14,17 +15,31 @@ import (
 )
 
 type School struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"-"`
+	Name      string     `json:"name"`
+	Level     string     `json:"level"`
+	Contact   string     `json:"contact"`
+	Phone     string     `json:"phone"`
+	Email     string     `json:"email,omitempty"`
+	Website   string     `json:"website,omitempty"`
+	Address   string     `json:"address"`
+	Mode      []string   `json:"mode"`
+	Latitude  *float64   `json:"latitude"`
+	Longitude *float64   `json:"longitude"`
+	Distance  *float64   `json:"distance_km,omitempty"`
+	OwnerID   *int64     `json:"owner_id"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy *int64     `json:"deleted_by,omitempty"`
+	Version   int32      `json:"version"`
+}
+
+// A Collaborator is a user the owner of a school has shared it with
+type Collaborator struct {
+	UserID    int64     `json:"user_id"`
 	Name      string    `json:"name"`
-	Level     string    `json:"level"`
-	Contact   string    `json:"contact"`
-	Phone     string    `json:"phone"`
-	Email     string    `json:"email,omitempty"`
-	Website   string    `json:"website,omitempty"`
-	Address   string    `json:"address"`
-	Mode      []string  `json:"mode"`
-	Version   int32     `json:"version"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 func ValidateSchool(v *validator.Validator, school *School) {
@@ -52,8 +67,18 @@ func ValidateSchool(v *validator.Validator, school *School) {
 
 	v.Check(school.Mode != nil, "mode", "must be provided")
 	v.Check(len(school.Mode) >= 1, "mode", "must contain at least 1 entry")
-	v.Check(len(school.Mode) >= 5, "mode", "must contain at most 5 entries")
+	v.Check(len(school.Mode) <= 5, "mode", "must contain at most 5 entries")
 	v.Check(validator.Unique(school.Mode), "mode", "must not contain duplicate entires")
+
+	//The location is optional, but a latitude needs a longitude and the other way around
+	v.Check(school.Longitude != nil || school.Latitude == nil, "longitude", "must be provided with a latitude")
+	v.Check(school.Latitude != nil || school.Longitude == nil, "latitude", "must be provided with a longitude")
+	if school.Latitude != nil {
+		v.Check(*school.Latitude >= -90 && *school.Latitude <= 90, "latitude", "must be between -90 and 90")
+	}
+	if school.Longitude != nil {
+		v.Check(*school.Longitude >= -180 && *school.Longitude <= 180, "longitude", "must be between -180 and 180")
+	}
 }
 
 // Define a SchoolModel which wraps a sql.DB connection pool
@@ -64,8 +89,8 @@ type SchoolModel struct {
 // Insert() allows us to create a new school
 func (m SchoolModel) Insert(school *School) error {
 	query := `
-		INSERT INTO schools (name, level, contact, phone, email, website, address, mode)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO schools (name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING id, created_at, version
 	`
 	//Create a context
@@ -74,12 +99,98 @@ func (m SchoolModel) Insert(school *School) error {
 	defer cancel()
 
 	//Collect the data fields into a slice
-	args := []interface{}{school.Name, school.Level, school.Contact, school.Phone, school.Email, school.Website, school.Address, pq.Array(school.Mode)}
+	args := []interface{}{school.Name, school.Level, school.Contact, school.Phone, school.Email, school.Website, school.Address, pq.Array(school.Mode), school.Latitude, school.Longitude, school.OwnerID}
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&school.ID, &school.CreatedAt, &school.Version)
 
 }
 
+// InsertMany() creates several schools in a single transaction, either all of
+// them are created or none are
+func (m SchoolModel) InsertMany(schools []*School) error {
+	query := `
+		INSERT INTO schools (name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, version
+	`
+	//A large import gets more time than a single insert
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, school := range schools {
+		args := []interface{}{school.Name, school.Level, school.Contact, school.Phone, school.Email, school.Website, school.Address, pq.Array(school.Mode), school.Latitude, school.Longitude, school.OwnerID}
+		err = stmt.QueryRowContext(ctx, args...).Scan(&school.ID, &school.CreatedAt, &school.Version)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SchoolKey() is what two schools have in common when they are the same school
+// listed twice: the same name at the same address, ignoring case and spacing
+func SchoolKey(name, address string) string {
+	return normalizeSchoolText(name) + "\x00" + normalizeSchoolText(address)
+}
+
+// normalizeSchoolText() lowercases a value and collapses its whitespace, the same
+// as the regexp_replace() in ExistingKeys()
+func normalizeSchoolText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// ExistingKeys() reports which of the schools are already in the directory,
+// as a set of SchoolKey() values. Schools in the trash are not counted
+func (m SchoolModel) ExistingKeys(schools []*School) (map[string]bool, error) {
+	names := make([]string, len(schools))
+	addresses := make([]string, len(schools))
+	for i, school := range schools {
+		names[i] = normalizeSchoolText(school.Name)
+		addresses[i] = normalizeSchoolText(school.Address)
+	}
+	query := `
+		SELECT name, address
+		FROM schools
+		WHERE deleted_at IS NULL
+		AND (REGEXP_REPLACE(LOWER(BTRIM(name)), '\s+', ' ', 'g'), REGEXP_REPLACE(LOWER(BTRIM(address)), '\s+', ' ', 'g'))
+		IN (SELECT * FROM UNNEST($1::text[], $2::text[]))
+	`
+	//Checking a whole import gets more time than a single lookup
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(names), pq.Array(addresses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := map[string]bool{}
+	for rows.Next() {
+		var name, address string
+		if err := rows.Scan(&name, &address); err != nil {
+			return nil, err
+		}
+		keys[SchoolKey(name, address)] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 // Get() allows us to retrieve a specific School
 func (m SchoolModel) Get(id int64) (*School, error) {
 	//Ensure that there is a valid id
@@ -89,9 +200,10 @@ func (m SchoolModel) Get(id int64) (*School, error) {
 
 	//Contruct our query with the given id
 	query := `
-		SELECT id, created_at, name, level, contact, phone, email, website, address, mode, version
+		SELECT id, created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, version
 		FROM schools
 		WHERE id = $1
+		AND deleted_at IS NULL
 	`
 
 	//Declare a School variable to hold the returned data
@@ -114,6 +226,9 @@ func (m SchoolModel) Get(id int64) (*School, error) {
 		&school.Website,
 		&school.Address,
 		pq.Array(&school.Mode),
+		&school.Latitude,
+		&school.Longitude,
+		&school.OwnerID,
 		&school.Version,
 	)
 	//Handle any errors
@@ -137,12 +252,13 @@ func (m SchoolModel) Update(school *School) error {
 	//create a query
 	query := `
 		UPDATE schools
-		SET name = $1, level = $2, contact = $3, phone = $4, email = $5, website = $6, address = $7, mode = $8, version = version + 1
-		WHERE id = $9
-		AND version = $10
+		SET name = $1, level = $2, contact = $3, phone = $4, email = $5, website = $6, address = $7, mode = $8, latitude = $9, longitude = $10, version = version + 1
+		WHERE id = $11
+		AND version = $12
+		AND deleted_at IS NULL
 		RETURNING version
 	`
-	args := []interface{}{school.Name, school.Level, school.Contact, school.Phone, school.Email, school.Website, school.Address, pq.Array(school.Mode), school.ID, school.Version}
+	args := []interface{}{school.Name, school.Level, school.Contact, school.Phone, school.Email, school.Website, school.Address, pq.Array(school.Mode), school.Latitude, school.Longitude, school.ID, school.Version}
 
 	//Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -162,17 +278,19 @@ func (m SchoolModel) Update(school *School) error {
 	return nil
 }
 
-// Delete() removes a specific school
-func (m SchoolModel) Delete(id int64) error {
+// Delete() moves a specific school to the trash, recording who deleted it
+func (m SchoolModel) Delete(id int64, deletedBy int64) error {
 	//Ensure that there is a valid id
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	//Create the delete query
+	//Create the delete query, the row stays until it is purged
 	query := `
-		DELETE FROM schools
+		UPDATE schools
+		SET deleted_at = NOW(), deleted_by = $2
 		WHERE id = $1
+		AND deleted_at IS NULL
 	`
 
 	//Create a context
@@ -181,7 +299,7 @@ func (m SchoolModel) Delete(id int64) error {
 	defer cancel()
 
 	//Execute this query
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, deletedBy)
 	if err != nil {
 		return err
 	}
@@ -200,24 +318,27 @@ func (m SchoolModel) Delete(id int64) error {
 }
 
 // The GetAll() method returns a list of all the schools sorted by id
-func (m SchoolModel) GetAll(name string, level string, mode []string, filters Filters) ([]*School, Metadata, error) {
+// An ownerID of 0 returns schools no matter who owns them
+func (m SchoolModel) GetAll(name string, level string, mode []string, ownerID int64, filters Filters) ([]*School, Metadata, error) {
 	//Construct the query
 	query := fmt.Sprintf(`
 		SELECT COUNT(*) OVER(),
-		id,  created_at, name, level, contact, phone, email, website, address, mode, version
+		id,  created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, version
 		FROM schools
 		WHERE (to_tsvector('simple', name ) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (to_tsvector('simple', level ) @@ plainto_tsquery('simple', $2) OR $2 = '')
 		AND (mode @> $3 OR $3 = '{}')
+		AND (owner_id = $4 OR $4 = 0)
+		AND deleted_at IS NULL
 		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5
+		LIMIT $5 OFFSET $6
 		`, filters.sortColumn(), filters.sortOrder())
 	//Create a 3 second time out context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	//Execute the querY
-	args := []interface{}{name, level, pq.Array(mode), filters.limit(), filters.offset()}
+	args := []interface{}{name, level, pq.Array(mode), ownerID, filters.limit(), filters.offset()}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -244,6 +365,9 @@ func (m SchoolModel) GetAll(name string, level string, mode []string, filters Fi
 			&school.Website,
 			&school.Address,
 			pq.Array(&school.Mode),
+			&school.Latitude,
+			&school.Longitude,
+			&school.OwnerID,
 			&school.Version,
 		)
 		if err != nil {
@@ -260,3 +384,444 @@ func (m SchoolModel) GetAll(name string, level string, mode []string, filters Fi
 	//Return the slice of schools
 	return schools, metadata, nil
 }
+
+// IsCollaborator() checks if a school has been shared with a user
+func (m SchoolModel) IsCollaborator(schoolID, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM schools_collaborators
+			WHERE school_id = $1 AND user_id = $2
+		)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var shared bool
+	err := m.DB.QueryRowContext(ctx, query, schoolID, userID).Scan(&shared)
+	return shared, err
+}
+
+// GetCollaborators() returns the users a school has been shared with
+func (m SchoolModel) GetCollaborators(schoolID int64) ([]*Collaborator, error) {
+	query := `
+		SELECT users.id, users.name, users.email, schools_collaborators.created_at
+		FROM schools_collaborators
+		INNER JOIN users ON users.id = schools_collaborators.user_id
+		WHERE schools_collaborators.school_id = $1
+		ORDER BY schools_collaborators.created_at, users.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, schoolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []*Collaborator{}
+	for rows.Next() {
+		var collaborator Collaborator
+		err := rows.Scan(&collaborator.UserID, &collaborator.Name, &collaborator.Email, &collaborator.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, &collaborator)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return collaborators, nil
+}
+
+// AddCollaborator() shares a school with a user, sharing it twice is not an error
+func (m SchoolModel) AddCollaborator(schoolID, userID int64) error {
+	query := `
+		INSERT INTO schools_collaborators (school_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, schoolID, userID)
+	return err
+}
+
+// RemoveCollaborator() stops sharing a school with a user
+func (m SchoolModel) RemoveCollaborator(schoolID, userID int64) error {
+	query := `
+		DELETE FROM schools_collaborators
+		WHERE school_id = $1 AND user_id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, schoolID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Export() passes every school matching the filters to fn, sorted by id. The schools
+// are read from a server-side cursor a batch at a time, so the whole directory is
+// never held in memory. Cancelling ctx stops the export
+func (m SchoolModel) Export(ctx context.Context, name string, level string, mode []string, fn func(*School) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DECLARE schools_export NO SCROLL CURSOR FOR
+		SELECT id, created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, version
+		FROM schools
+		WHERE (to_tsvector('simple', name ) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (to_tsvector('simple', level ) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND (mode @> $3 OR $3 = '{}')
+		AND deleted_at IS NULL
+		ORDER BY id ASC
+	`
+	_, err = tx.ExecContext(ctx, query, name, level, pq.Array(mode))
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, `FETCH FORWARD 500 FROM schools_export`)
+		if err != nil {
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			var school School
+			err := rows.Scan(
+				&school.ID,
+				&school.CreatedAt,
+				&school.Name,
+				&school.Level,
+				&school.Contact,
+				&school.Phone,
+				&school.Email,
+				&school.Website,
+				&school.Address,
+				pq.Array(&school.Mode),
+				&school.Latitude,
+				&school.Longitude,
+				&school.OwnerID,
+				&school.Version,
+			)
+			if err == nil {
+				err = fn(&school)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			fetched++
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		//An empty batch means the cursor is exhausted
+		if fetched == 0 {
+			return nil
+		}
+	}
+}
+
+// The GetNearby() method returns the schools within radiusKm of a point, filtered,
+// sorted and paginated like GetAll(). Each school carries its distance from the point.
+// An earth box narrows the search down through the index before the exact distance is checked
+func (m SchoolModel) GetNearby(latitude float64, longitude float64, radiusKm float64, name string, level string, mode []string, filters Filters) ([]*School, Metadata, error) {
+	//Construct the query
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(),
+		id,  created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, version,
+		earth_distance(ll_to_earth($1, $2), ll_to_earth(latitude, longitude)) / 1000 AS distance
+		FROM schools
+		WHERE latitude IS NOT NULL
+		AND deleted_at IS NULL
+		AND earth_box(ll_to_earth($1, $2), $3 * 1000) @> ll_to_earth(latitude, longitude)
+		AND earth_distance(ll_to_earth($1, $2), ll_to_earth(latitude, longitude)) <= $3 * 1000
+		AND (to_tsvector('simple', name ) @@ plainto_tsquery('simple', $4) OR $4 = '')
+		AND (to_tsvector('simple', level ) @@ plainto_tsquery('simple', $5) OR $5 = '')
+		AND (mode @> $6 OR $6 = '{}')
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8
+		`, filters.sortColumn(), filters.sortOrder())
+	//Create a 3 second time out context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//Execute the query
+	args := []interface{}{latitude, longitude, radiusKm, name, level, pq.Array(mode), filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	//Close the result set
+	defer rows.Close()
+	totalRecords := 0
+	schools := []*School{}
+	for rows.Next() {
+		var school School
+		var distance float64
+		err := rows.Scan(
+			&totalRecords,
+			&school.ID,
+			&school.CreatedAt,
+			&school.Name,
+			&school.Level,
+			&school.Contact,
+			&school.Phone,
+			&school.Email,
+			&school.Website,
+			&school.Address,
+			pq.Array(&school.Mode),
+			&school.Latitude,
+			&school.Longitude,
+			&school.OwnerID,
+			&school.Version,
+			&distance,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		school.Distance = &distance
+		schools = append(schools, &school)
+	}
+	//Check for errors after looping through the resultset
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return schools, metadata, nil
+}
+
+// GetDeleted() retrieves a specific school from the trash
+func (m SchoolModel) GetDeleted(id int64) (*School, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, deleted_at, deleted_by, version
+		FROM schools
+		WHERE id = $1
+		AND deleted_at IS NOT NULL
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var school School
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&school.ID,
+		&school.CreatedAt,
+		&school.Name,
+		&school.Level,
+		&school.Contact,
+		&school.Phone,
+		&school.Email,
+		&school.Website,
+		&school.Address,
+		pq.Array(&school.Mode),
+		&school.Latitude,
+		&school.Longitude,
+		&school.OwnerID,
+		&school.DeletedAt,
+		&school.DeletedBy,
+		&school.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &school, nil
+}
+
+// GetAllOwnedBy() returns every school a user owns for their data export,
+// including the ones in the trash
+func (m SchoolModel) GetAllOwnedBy(userID int64) ([]*School, error) {
+	query := `
+		SELECT id, created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, deleted_at, deleted_by, version
+		FROM schools
+		WHERE owner_id = $1
+		ORDER BY id ASC
+	`
+	return m.getAllForUser(query, userID)
+}
+
+// GetAllSharedWith() returns every school a user collaborates on for their data
+// export, including the ones in the trash
+func (m SchoolModel) GetAllSharedWith(userID int64) ([]*School, error) {
+	query := `
+		SELECT schools.id, schools.created_at, schools.name, schools.level, schools.contact, schools.phone, schools.email,
+		schools.website, schools.address, schools.mode, schools.latitude, schools.longitude, schools.owner_id,
+		schools.deleted_at, schools.deleted_by, schools.version
+		FROM schools
+		INNER JOIN schools_collaborators ON schools_collaborators.school_id = schools.id
+		WHERE schools_collaborators.user_id = $1
+		ORDER BY schools.id ASC
+	`
+	return m.getAllForUser(query, userID)
+}
+
+// getAllForUser() runs one of the export queries above
+func (m SchoolModel) getAllForUser(query string, userID int64) ([]*School, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schools := []*School{}
+	for rows.Next() {
+		var school School
+		err := rows.Scan(
+			&school.ID,
+			&school.CreatedAt,
+			&school.Name,
+			&school.Level,
+			&school.Contact,
+			&school.Phone,
+			&school.Email,
+			&school.Website,
+			&school.Address,
+			pq.Array(&school.Mode),
+			&school.Latitude,
+			&school.Longitude,
+			&school.OwnerID,
+			&school.DeletedAt,
+			&school.DeletedBy,
+			&school.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		schools = append(schools, &school)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return schools, nil
+}
+
+// The GetAllDeleted() method returns a paginated list of the schools in the trash
+func (m SchoolModel) GetAllDeleted(filters Filters) ([]*School, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(),
+		id, created_at, name, level, contact, phone, email, website, address, mode, latitude, longitude, owner_id, deleted_at, deleted_by, version
+		FROM schools
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2
+		`, filters.sortColumn(), filters.sortOrder())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	schools := []*School{}
+	for rows.Next() {
+		var school School
+		err := rows.Scan(
+			&totalRecords,
+			&school.ID,
+			&school.CreatedAt,
+			&school.Name,
+			&school.Level,
+			&school.Contact,
+			&school.Phone,
+			&school.Email,
+			&school.Website,
+			&school.Address,
+			pq.Array(&school.Mode),
+			&school.Latitude,
+			&school.Longitude,
+			&school.OwnerID,
+			&school.DeletedAt,
+			&school.DeletedBy,
+			&school.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		schools = append(schools, &school)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return schools, metadata, nil
+}
+
+// Restore() takes a school back out of the trash
+func (m SchoolModel) Restore(school *School) error {
+	query := `
+		UPDATE schools
+		SET deleted_at = NULL, deleted_by = NULL, version = version + 1
+		WHERE id = $1
+		AND version = $2
+		AND deleted_at IS NOT NULL
+		RETURNING version
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, school.ID, school.Version).Scan(&school.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	school.DeletedAt = nil
+	school.DeletedBy = nil
+	return nil
+}
+
+// PurgeDeleted() permanently removes up to batchSize schools that were put in
+// the trash before the cutoff and reports how many went
+func (m SchoolModel) PurgeDeleted(cutoff time.Time, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM schools
+		WHERE id IN (
+			SELECT id FROM schools
+			WHERE deleted_at < $1
+			LIMIT $2
+		)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
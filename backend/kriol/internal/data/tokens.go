@@ -7,8 +7,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
+	"github.com/lib/pq"
 	"kriol.michaelgomez.net/internal/validator"
 )
 
@@ -16,15 +18,28 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeMFAPending     = "mfa_pending"
+	ScopeEmailChange    = "email-change"
+)
+
+var (
+	ErrTokenReused = errors.New("refresh token reused")
 )
 
 // Define the Token type
 type Token struct {
-	Plaintext string    `json:"token"`
+	ID        int64     `json:"id"`
+	Plaintext string    `json:"token,omitempty"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	Family    string    `json:"-"`
 }
 
 // The generate token function returns a token
@@ -35,16 +50,11 @@ func generateqToken(userID int64, ttl time.Duration, scope string) (*Token, erro
 		Scope:  scope,
 	}
 
-	//Create a byte slice to hold random values and fill it with values
-	//from CSPRNG
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+	plaintext, err := generateRandomString()
 	if err != nil {
 		return nil, err
 	}
-
-	//Emcode the byte slice into a base-32 encoded string
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Plaintext = plaintext
 	//Hash the string token
 	hash := sha256.Sum256([]byte(token.Plaintext))
 	token.Hash = hash[:]
@@ -52,6 +62,20 @@ func generateqToken(userID int64, ttl time.Duration, scope string) (*Token, erro
 	return token, nil
 }
 
+// The generateRandomString() function returns 16 random bytes as a 26 byte base-32 string
+func generateRandomString() (string, error) {
+	//Create a byte slice to hold random values and fill it with values
+	//from CSPRNG
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	//Emcode the byte slice into a base-32 encoded string
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
 // check that plaintext token is 26 btyes long
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be 26 bytes long")
@@ -73,25 +97,286 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// Create and insert a token that remembers the client it was issued to
+func (m TokenModel) NewForClient(userID int64, ttl time.Duration, scope, userAgent, ipAddress string) (*Token, error) {
+	token, err := generateqToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	token.IPAddress = ipAddress
+	err = m.Insert(token)
+	return token, err
+}
+
+// Create an authentication and refresh token pair that share a family.
+// A new family is started when family is empty
+func (m TokenModel) NewPair(userID int64, authTTL, refreshTTL time.Duration, family, userAgent, ipAddress string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	token, refreshToken, err := insertPair(ctx, tx, userID, authTTL, refreshTTL, family, userAgent, ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, refreshToken, tx.Commit()
+}
+
+// insertPair() inserts an authentication and refresh token pair inside tx
+func insertPair(ctx context.Context, tx *sql.Tx, userID int64, authTTL, refreshTTL time.Duration, family, userAgent, ipAddress string) (*Token, *Token, error) {
+	if family == "" {
+		var err error
+		family, err = generateRandomString()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	query := `
+		insert into tokens (hash, user_id, expiry, scope, user_agent, ip_address, family)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning id, created_at
+	`
+	var tokens []*Token
+	for _, t := range []struct {
+		ttl   time.Duration
+		scope string
+	}{{authTTL, ScopeAuthentication}, {refreshTTL, ScopeRefresh}} {
+		token, err := generateqToken(userID, t.ttl, t.scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		token.UserAgent = userAgent
+		token.IPAddress = ipAddress
+		token.Family = family
+		args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IPAddress, token.Family}
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens[0], tokens[1], nil
+}
+
+// Rotate a refresh token: mark it as used and issue a new pair in its family, all in
+// one transaction. The old token is locked so concurrent refreshes are serialized.
+// Presenting a token that was already used revokes its whole family and returns ErrTokenReused
+func (m TokenModel) RotateRefresh(tokenPlaintext string, authTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*Token, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		select id, user_id, expiry, family, used_at
+		from tokens
+		where hash = $1 and scope = $2
+		for update
+	`
+	var old Token
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&old.ID, &old.UserID, &old.Expiry, &old.Family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	//A used token being presented again means it leaked, so revoke the family
+	if usedAt.Valid {
+		if old.Family != "" {
+			_, err = tx.ExecContext(ctx, `delete from tokens where family = $1`, old.Family)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err = tx.Commit(); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, ErrTokenReused
+	}
+	if !old.Expiry.After(time.Now()) {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `update tokens set used_at = now() where id = $1`, old.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	//Only the newest access token of a family stays valid
+	_, err = tx.ExecContext(ctx, `delete from tokens where family = $1 and scope = $2`, old.Family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, refreshToken, err := insertPair(ctx, tx, old.UserID, authTTL, refreshTTL, old.Family, userAgent, ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, refreshToken, tx.Commit()
+}
+
+// Delete every token of a family
+func (m TokenModel) DeleteFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+	query := `
+		delete from tokens
+		where family = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
+
 // Insert will insert a entry into the tokens table
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		insert into tokens (hash, user_id, expiry, scope)
-		values ($1, $2, $3, $4)
+		insert into tokens (hash, user_id, expiry, scope, user_agent, ip_address, family)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning id, created_at
 	`
 	args := []interface{}{
 		token.Hash,
 		token.UserID,
 		token.Expiry,
 		token.Scope,
+		token.UserAgent,
+		token.IPAddress,
+		token.Family,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// Get all the unexpired tokens of a scope that belong to a user
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	query := `
+		select id, user_id, created_at, expiry, scope, user_agent, ip_address
+		from tokens
+		where scope = $1 and user_id = $2 and expiry > $3
+		order by created_at desc, id desc
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.CreatedAt,
+			&token.Expiry,
+			&token.Scope,
+			&token.UserAgent,
+			&token.IPAddress,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Delete a single token using its plaintext value, along with the rest of its family
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		delete from tokens
+		where (scope = $1 and hash = $2)
+		or family in (select family from tokens where scope = $1 and hash = $2 and family <> '')
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
 	return err
 }
 
+// Take a single-use token by deleting it. ErrRecordNotFound means the token was
+// unknown or already taken, so of two concurrent requests only one succeeds
+func (m TokenModel) Take(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		delete from tokens
+		where scope = $1 and hash = $2 and expiry > $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:], time.Now())
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete a single token by id, along with the rest of its family, as long as it belongs to the user
+func (m TokenModel) DeleteForUser(scope string, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		delete from tokens
+		where (scope = $1 and id = $2 and user_id = $3)
+		or family in (select family from tokens where scope = $1 and id = $2 and user_id = $3 and family <> '')
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, scope, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 func (m TokenModel) DeleteAllForUsers(scope string, userID int64) error {
 	query := `
 		delete from tokens
@@ -103,3 +388,61 @@ func (m TokenModel) DeleteAllForUsers(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// Delete every token that belongs to a user regardless of its scope
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+		delete from tokens
+		where user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Delete every session of a user, which logs them out everywhere
+func (m TokenModel) DeleteSessionsForUser(userID int64) error {
+	//No token hashes to the empty string, so nothing is kept
+	return m.DeleteSessionsForUserExcept(userID, "")
+}
+
+// Delete the sessions of a user except the one using the given token and its family
+func (m TokenModel) DeleteSessionsForUserExcept(userID int64, keepPlaintext string) error {
+	keepHash := sha256.Sum256([]byte(keepPlaintext))
+	query := `
+		delete from tokens
+		where user_id = $1
+		and scope = any($2)
+		and hash <> $3
+		and family not in (select family from tokens where hash = $3 and family <> '')
+	`
+	scopes := []string{ScopeAuthentication, ScopeRefresh, ScopeMFAPending, ScopePasswordReset}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(scopes), keepHash[:])
+	return err
+}
+
+// DeleteExpired() removes up to batchSize expired tokens and reports how many went.
+// API keys without an expiry are never removed
+func (m TokenModel) DeleteExpired(batchSize int) (int64, error) {
+	query := `
+		delete from tokens
+		where id in (
+			select id from tokens
+			where expiry < now()
+			limit $1
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
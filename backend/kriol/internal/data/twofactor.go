@@ -0,0 +1,231 @@
+// Filename: kriol/backend/kriol/internal/data/twofactor.go
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The number of recovery codes handed out when 2FA is enabled
+const recoveryCodeCount = 10
+
+// Define the TwoFactor type
+type TwoFactor struct {
+	UserID       int64     `json:"-"`
+	Secret       string    `json:"-"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Define the TwoFactor model
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Get the 2FA settings of a user
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+		select user_id, secret, enabled, last_used_step, created_at
+		from users_two_factor
+		where user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var twoFactor TwoFactor
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.Secret,
+		&twoFactor.Enabled,
+		&twoFactor.LastUsedStep,
+		&twoFactor.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &twoFactor, nil
+}
+
+// Start an enrollment with a new secret, replacing any enrollment that was never confirmed
+func (m TwoFactorModel) Enroll(userID int64, secret string) error {
+	query := `
+		insert into users_two_factor (user_id, secret)
+		values ($1, $2)
+		on conflict (user_id) do update
+		set secret = excluded.secret, last_used_step = 0, created_at = now()
+		where users_two_factor.enabled = false
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	//2FA is already enabled
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Use a time step. A step can only be used once so that a code cannot be
+// replayed. ErrEditConflict is returned for a replay
+func (m TwoFactorModel) UseStep(userID, step int64) error {
+	query := `
+		update users_two_factor
+		set last_used_step = $2
+		where user_id = $1 and last_used_step < $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Turn 2FA off and remove the recovery codes
+func (m TwoFactorModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from users_two_factor where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Confirm an enrollment with the time step of a valid code. Using the step, turning
+// 2FA on and creating the recovery codes happen in one transaction, so 2FA is never
+// on without recovery codes. ErrEditConflict is returned for a replayed step or when
+// 2FA is already enabled. The plaintext codes are returned only once
+func (m TwoFactorModel) Confirm(userID, step int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRandomString()
+		if err != nil {
+			return nil, err
+		}
+		//Short, lower case codes are easier to copy down
+		codes[i] = strings.ToLower(code[:16])
+		hash := sha256.Sum256([]byte(codes[i]))
+		hashes[i] = hash[:]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		update users_two_factor
+		set last_used_step = $2, enabled = true
+		where user_id = $1 and last_used_step < $2 and enabled = false
+	`
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		insert into recovery_codes (hash, user_id)
+		select unnest($2::bytea[]), $1
+	`
+	_, err = tx.ExecContext(ctx, query, userID, pq.ByteaArray(hashes))
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Use up a recovery code, false is returned if the code is unknown or already used
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	query := `
+		update recovery_codes
+		set used_at = now()
+		where hash = $1 and user_id = $2 and used_at is null
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Count the recovery codes a user has left
+func (m TwoFactorModel) CountRecoveryCodes(userID int64) (int, error) {
+	query := `
+		select count(*)
+		from recovery_codes
+		where user_id = $1 and used_at is null
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
@@ -3,12 +3,20 @@
 package data
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	"kriol.michaelgomez.net/internal/validator"
 )
@@ -27,6 +35,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Disabled  bool      `json:"disabled"`
 	Version   int       `json:"-"`
 }
 
@@ -41,21 +50,56 @@ type password struct {
 	hash      []byte
 }
 
+// The argon2id parameters used for new hashes. Changing them causes existing
+// hashes to be upgraded the next time their owner logs in
+const (
+	argon2Memory      = 64 * 1024 //KiB
+	argon2Iterations  = 3
+	argon2Parallelism = 2
+	argon2SaltLength  = 16
+	argon2KeyLength   = 32
+)
+
+// Hashes are stored in the PHC string format so the algorithm and parameters
+// travel with them, e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+var argon2Prefix = []byte("$argon2id$")
+
 // The set() method stores the has of the plaintext password
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
 	if err != nil {
 		return err
 	}
+	key := argon2.IDKey([]byte(plaintextPassword), salt, argon2Iterations, argon2Memory, argon2Parallelism, argon2KeyLength)
+
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Iterations, argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
 
 	p.plaintext = &plaintextPassword
-	p.hash = hash
+	p.hash = []byte(hash)
 
 	return nil
 }
 
-// The matches() method checks if the supplied password is correct
+// The matches() method checks if the supplied password is correct. Both argon2id
+// hashes and the bcrypt hashes we used before are understood
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	//Users linked through an identity provider may not have a password
+	if p.hash == nil {
+		return false, nil
+	}
+	if bytes.HasPrefix(p.hash, argon2Prefix) {
+		return matchesArgon2(p.hash, plaintextPassword)
+	}
+
+	//bcrypt only ever looked at the first 72 bytes, longer passwords cannot have been set with it
+	if len(plaintextPassword) > 72 {
+		return false, nil
+	}
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -68,6 +112,71 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// The NeedsRehash() method reports whether the hash was made with an older algorithm
+// or parameters and should be replaced once the plaintext is known
+func (p *password) NeedsRehash() bool {
+	if p.hash == nil {
+		return false
+	}
+	memory, iterations, parallelism, _, _, err := decodeArgon2(p.hash)
+	if err != nil {
+		return true
+	}
+	return memory != argon2Memory || iterations != argon2Iterations || parallelism != argon2Parallelism
+}
+
+// matchesArgon2() hashes the plaintext with the parameters stored in the hash and compares the keys
+func matchesArgon2(hash []byte, plaintextPassword string) (bool, error) {
+	memory, iterations, parallelism, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+	otherKey := argon2.IDKey([]byte(plaintextPassword), salt, iterations, memory, parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+// decodeArgon2() splits an argon2id PHC string into its parameters, salt and key
+func decodeArgon2(hash []byte) (memory uint32, iterations uint32, parallelism uint8, salt []byte, key []byte, err error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return 0, 0, 0, nil, nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return 0, 0, 0, nil, nil, err
+	}
+	if version != argon2.Version {
+		return 0, 0, 0, nil, nil, errors.New("unsupported argon2 version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism)
+	if err != nil {
+		return 0, 0, 0, nil, nil, err
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return 0, 0, 0, nil, nil, err
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return 0, 0, 0, nil, nil, err
+	}
+	return memory, iterations, parallelism, salt, key, nil
+}
+
+// The IsSet() method reports whether the user has a password at all
+func (p *password) IsSet() bool {
+	return p.hash != nil
+}
+
+// The value() method returns the hash for the database, a missing hash is stored as null
+func (p *password) value() interface{} {
+	if p.hash == nil {
+		return nil
+	}
+	return p.hash
+}
+
 // Validate the client requst
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
@@ -77,7 +186,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintex(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be atleast 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= 1024, "password", "must not be more than 1024 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -86,13 +195,15 @@ func ValidateUser(v *validator.Validator, user *User) {
 	//validate the email
 	ValidateEmail(v, user.Email)
 
-	//validate the password
+	//validate the password, new passwords must also be hard to guess
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintex(v, *user.Password.plaintext)
+		ValidatePasswordStrength(v, *user.Password.plaintext, user.Name, user.Email)
 	}
 
-	//Ensure a hash of the password was created
-	if user.Password.hash == nil {
+	//Ensure a hash of the password was created. Users linked through an
+	//identity provider may not have a password at all
+	if user.Password.plaintext != nil && user.Password.hash == nil {
 		panic("missing password hash for the user")
 	}
 }
@@ -106,14 +217,14 @@ type UserModel struct {
 func (m UserModel) Insert(user *User) error {
 	//Create our query
 	query := `
-		insert into users (name, email, password_hash, activated)
-		values ($1, $2, $3, $4)
+		insert into users (name, email, password_hash, activated, activated_at)
+		values ($1, $2, $3, $4, case when $4 then now() end)
 		returning id, created_at, version
 	`
 	args := []interface{}{
 		user.Name,
 		user.Email,
-		user.Password.hash,
+		user.Password.value(),
 		user.Activated,
 	}
 
@@ -132,10 +243,48 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get user based on their id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		select id, created_at, name, email, password_hash, activated, disabled, version
+		from users
+		where id = $1
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // Get user based on their email
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		select id, created_at, name, email, password_hash, activated, version
+		select id, created_at, name, email, password_hash, activated, disabled, version
 		from users
 		where email = $1
 	`
@@ -152,6 +301,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 	)
 	if err != nil {
@@ -170,14 +320,15 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 		update users
-		set name = $1, email = $2, password_hash = $3, activated = $4, version + 1
+		set name = $1, email = $2, password_hash = $3, activated = $4,
+		activated_at = case when $4 then coalesce(activated_at, now()) else activated_at end, version = version + 1
 		where id = $5 and version = $6
 		returning version
 	`
 	args := []interface{}{
 		user.Name,
 		user.Email,
-		user.Password.hash,
+		user.Password.value(),
 		user.Activated,
 		user.ID,
 		user.Version,
@@ -190,6 +341,8 @@ func (m UserModel) Update(user *User) error {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -197,11 +350,52 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// UpdateStatus() is how an admin activates, disables or re-enables an account.
+// Disabling an account also revokes every token and API key it holds, in the
+// same transaction. The disabled flag is only ever written here, so neither
+// the activation flow nor the user can clear it
+func (m UserModel) UpdateStatus(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		update users
+		set activated = $1, activated_at = case when $1 then coalesce(activated_at, now()) else activated_at end,
+		disabled = $2, version = version + 1
+		where id = $3 and version = $4
+		returning version
+	`
+	args := []interface{}{user.Activated, user.Disabled, user.ID, user.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if user.Disabled {
+		_, err = tx.ExecContext(ctx, `delete from tokens where user_id = $1`, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (m UserModel) GetForToken(tokenScope, TokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(TokenPlaintext))
 	//Setup query
 	query := `
-		select users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		select users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version
 		from users
 		inner join tokens
 		on users.id = tokens.user_id
@@ -222,6 +416,7 @@ func (m UserModel) GetForToken(tokenScope, TokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 	)
 
@@ -235,3 +430,181 @@ func (m UserModel) GetForToken(tokenScope, TokenPlaintext string) (*User, error)
 	}
 	return &user, nil
 }
+
+// Remember the email address a user wants to change to, replacing any earlier request
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+		insert into email_changes (user_id, new_email)
+		values ($1, $2)
+		on conflict (user_id) do update
+		set new_email = excluded.new_email, created_at = now()
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+// Take the email address a user wants to change to, a request can only be taken once
+func (m UserModel) TakePendingEmail(userID int64) (string, error) {
+	query := `
+		delete from email_changes
+		where user_id = $1
+		returning new_email
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var email string
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return email, nil
+}
+
+// Delete a user, their tokens, permissions and other linked rows go with them
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		delete from users
+		where id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAll() method returns a filtered, sorted and paginated list of users
+func (m UserModel) GetAll(name string, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		select count(*) over(),
+		id, created_at, name, email, password_hash, activated, disabled, version
+		from users
+		where (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) or $1 = '')
+		and (strpos(email, $2) > 0 or $2 = '')
+		and (activated = $3 or $3 is null)
+		order by %s %s, id asc
+		limit $4 offset $5
+	`, filters.sortColumn(), filters.sortOrder())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{name, email, activated, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Disabled,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// DeleteUnactivated() removes up to batchSize accounts that were never activated,
+// were created before the cutoff and have no activation token left to use. Accounts
+// that were activated once and later switched off are never touched. The failed logins
+// of the removed accounts and abandoned identity provider logins go in the same pass
+func (m UserModel) DeleteUnactivated(cutoff time.Time, batchSize int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		delete from users
+		where id in (
+			select id from users
+			where activated = false
+			and activated_at is null
+			and created_at < $1
+			and not exists (
+				select 1 from tokens
+				where tokens.user_id = users.id
+				and tokens.scope = $2
+				and tokens.expiry > now()
+			)
+			limit $3
+		)
+		returning email
+	`
+	rows, err := tx.QueryContext(ctx, query, cutoff, ScopeActivation, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	emails := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		emails = append(emails, email)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from login_failures where email = any($1)`, pq.Array(emails))
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `delete from oidc_logins where expiry < now()`)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int64(len(emails)), nil
+}
@@ -0,0 +1,360 @@
+// Filename: kriol/backend/kriol/internal/oidc/oidc.go
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrTokenRequest   = errors.New("token request failed")
+)
+
+// Provider is an OpenID Connect identity provider that we trust for logins
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	client    *http.Client
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// The parts of the discovery document we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims holds the ID token claims we care about
+type Claims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expiry        int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+// New() creates a provider, the discovery document is fetched on first use
+func New(name, issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Parse() reads a provider from a "name,issuer,client_id,client_secret,redirect_url" string
+func Parse(value string) (*Provider, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 5 {
+		return nil, errors.New("oidc provider must be name,issuer,client_id,client_secret,redirect_url")
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+		if parts[i] == "" && i != 3 {
+			return nil, errors.New("oidc provider is missing a value")
+		}
+	}
+	return New(parts[0], parts[1], parts[2], parts[3], parts[4]), nil
+}
+
+// GenerateVerifier() returns a random PKCE code verifier
+func GenerateVerifier() (string, error) {
+	return randomString(32)
+}
+
+// GenerateState() returns a random value for the state and nonce parameters
+func GenerateState() (string, error) {
+	return randomString(16)
+}
+
+func randomString(size int) (string, error) {
+	randomBytes := make([]byte, size)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// Challenge() returns the S256 PKCE challenge for a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL() builds the URL the user is sent to in order to log in
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange() swaps an authorization code for the verified claims of the ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || response.IDToken == "" {
+		return nil, fmt.Errorf("oidc: %w with status %d: %s %s", ErrTokenRequest, status, response.Error, response.ErrorDescription)
+	}
+
+	return p.verify(ctx, d, response.IDToken, nonce)
+}
+
+// Verified() reports whether the provider has vouched for the email address
+func (c *Claims) Verified() bool {
+	var verified bool
+	if json.Unmarshal(c.EmailVerified, &verified) == nil {
+		return verified
+	}
+	//Some providers send the value as a string
+	var value string
+	return json.Unmarshal(c.EmailVerified, &value) == nil && value == "true"
+}
+
+// The verify() method checks the signature and the claims of an RS256 ID token
+func (p *Provider) verify(ctx context.Context, d *discovery, rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil || header.Algorithm != "RS256" {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := p.getKey(ctx, d, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	//The audience can be a single string or a list of strings
+	var audience []string
+	var single string
+	if json.Unmarshal(claims.Audience, &single) == nil {
+		audience = []string{single}
+	} else if json.Unmarshal(claims.Audience, &audience) != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	switch {
+	case claims.Issuer != d.Issuer:
+		return nil, ErrInvalidIDToken
+	case !contains(audience, p.ClientID):
+		return nil, ErrInvalidIDToken
+	case time.Now().Unix() >= claims.Expiry:
+		return nil, ErrInvalidIDToken
+	case claims.Nonce != nonce:
+		return nil, ErrInvalidIDToken
+	case claims.Subject == "":
+		return nil, ErrInvalidIDToken
+	}
+	return &claims, nil
+}
+
+// The getDiscovery() method fetches and caches the discovery document
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d discovery
+	status, err := p.do(req, &d)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery request failed with status %d", status)
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q does not match discovery issuer %q", p.Issuer, d.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// The getKey() method returns a signing key, refetching the key set for unknown key ids
+func (p *Provider) getKey(ctx context.Context, d *discovery, keyID string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[keyID]; ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	status, err := p.do(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: key set request failed with status %d", status)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+	return key, nil
+}
+
+// The do() method sends a request and decodes the JSON response body
+func (p *Provider) do(req *http.Request, dst interface{}) (int, error) {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(res.Body).Decode(dst)
+	if err != nil && res.StatusCode == http.StatusOK {
+		return 0, err
+	}
+	return res.StatusCode, nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	js, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, dst)
+}
+
+func contains(list []string, value string) bool {
+	for i := range list {
+		if list[i] == value {
+			return true
+		}
+	}
+	return false
+}
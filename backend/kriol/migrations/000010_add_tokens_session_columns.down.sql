@@ -0,0 +1,5 @@
+-- Filename :migrations/000010_add_tokens_session_columns.down.sql
+alter table tokens drop column if exists ip_address;
+alter table tokens drop column if exists user_agent;
+alter table tokens drop column if exists created_at;
+alter table tokens drop column if exists id;
@@ -0,0 +1,5 @@
+-- Filename :migrations/000010_add_tokens_session_columns.up.sql
+alter table tokens add column if not exists id bigserial unique;
+alter table tokens add column if not exists created_at timestamp(0) with time zone not null default now();
+alter table tokens add column if not exists user_agent text not null default '';
+alter table tokens add column if not exists ip_address text not null default '';
@@ -0,0 +1,4 @@
+-- Filename :migrations/000011_add_tokens_refresh_columns.down.sql
+drop index if exists tokens_family_idx;
+alter table tokens drop column if exists used_at;
+alter table tokens drop column if exists family;
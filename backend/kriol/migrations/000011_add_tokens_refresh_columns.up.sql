@@ -0,0 +1,4 @@
+-- Filename :migrations/000011_add_tokens_refresh_columns.up.sql
+alter table tokens add column if not exists family text not null default '';
+alter table tokens add column if not exists used_at timestamp(0) with time zone;
+create index if not exists tokens_family_idx on tokens (family) where family <> '';
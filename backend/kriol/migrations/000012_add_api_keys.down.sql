@@ -0,0 +1,6 @@
+-- Filename :migrations/000012_add_api_keys.down.sql
+drop table if exists tokens_permissions;
+delete from tokens where expiry is null;
+alter table tokens drop column if exists last_used_at;
+alter table tokens drop column if exists name;
+alter table tokens alter column expiry set not null;
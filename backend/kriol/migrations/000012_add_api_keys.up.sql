@@ -0,0 +1,11 @@
+-- Filename :migrations/000012_add_api_keys.up.sql
+alter table tokens alter column expiry drop not null;
+alter table tokens add column if not exists name text not null default '';
+alter table tokens add column if not exists last_used_at timestamp(0) with time zone;
+
+--an api key only carries a subset of its owner's permissions
+create table if not exists tokens_permissions(
+    token_id bigint not null references tokens (id) on delete cascade,
+    permission_id bigint not null references permissions (id) on delete cascade,
+    primary key (token_id, permission_id)
+);
@@ -0,0 +1,5 @@
+-- Filename :migrations/000013_create_login_failures_table.down.sql
+
+delete from permissions where code = 'users:admin';
+drop table if exists account_lockouts;
+drop table if exists login_failures;
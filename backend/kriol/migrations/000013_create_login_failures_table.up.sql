@@ -0,0 +1,21 @@
+-- Filename :migrations/000013_create_login_failures_table.up.sql
+
+create table if not exists login_failures(
+    id bigserial primary key,
+    email citext not null,
+    ip_address text not null,
+    created_at timestamp(0) with time zone not null default now()
+);
+
+create index if not exists login_failures_email_idx on login_failures (email, created_at);
+create index if not exists login_failures_ip_address_idx on login_failures (ip_address, created_at);
+
+--accounts that are temporarily locked after repeated failures
+create table if not exists account_lockouts(
+    user_id bigint primary key references users on delete cascade,
+    locked_until timestamp(0) with time zone not null,
+    created_at timestamp(0) with time zone not null default now()
+);
+
+insert into permissions (code)
+values ('users:admin');
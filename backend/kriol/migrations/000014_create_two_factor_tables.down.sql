@@ -0,0 +1,4 @@
+-- Filename :migrations/000014_create_two_factor_tables.down.sql
+
+drop table if exists recovery_codes;
+drop table if exists users_two_factor;
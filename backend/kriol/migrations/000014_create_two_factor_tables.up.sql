@@ -0,0 +1,16 @@
+-- Filename :migrations/000014_create_two_factor_tables.up.sql
+
+create table if not exists users_two_factor(
+    user_id bigint primary key references users on delete cascade,
+    secret text not null,
+    enabled bool not null default false,
+    last_used_step bigint not null default 0,
+    created_at timestamp(0) with time zone not null default now()
+);
+
+--single-use codes for when the authenticator app is lost
+create table if not exists recovery_codes(
+    hash bytea primary key,
+    user_id bigint not null references users on delete cascade,
+    used_at timestamp(0) with time zone
+);
@@ -0,0 +1,6 @@
+-- Filename :migrations/000015_add_oidc_logins.down.sql
+
+drop table if exists oidc_logins;
+drop table if exists users_identities;
+delete from users where password_hash is null;
+alter table users alter column password_hash set not null;
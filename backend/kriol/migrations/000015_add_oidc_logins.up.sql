@@ -0,0 +1,22 @@
+-- Filename :migrations/000015_add_oidc_logins.up.sql
+
+--users who only log in through an identity provider have no password
+alter table users alter column password_hash drop not null;
+
+--links an account at an identity provider to a user
+create table if not exists users_identities(
+    provider text not null,
+    subject text not null,
+    user_id bigint not null references users on delete cascade,
+    created_at timestamp(0) with time zone not null default now(),
+    primary key (provider, subject)
+);
+
+--logins that were started but have not come back from the provider yet
+create table if not exists oidc_logins(
+    state_hash bytea primary key,
+    provider text not null,
+    nonce text not null,
+    code_verifier text not null,
+    expiry timestamp(0) with time zone not null
+);
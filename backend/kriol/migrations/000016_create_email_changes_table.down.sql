@@ -0,0 +1,3 @@
+-- Filename :migrations/000016_create_email_changes_table.down.sql
+
+drop table if exists email_changes;
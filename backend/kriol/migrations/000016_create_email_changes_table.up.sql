@@ -0,0 +1,8 @@
+-- Filename :migrations/000016_create_email_changes_table.up.sql
+
+--an email address a user asked to change to that has not been confirmed yet
+create table if not exists email_changes(
+    user_id bigint primary key references users on delete cascade,
+    new_email citext not null,
+    created_at timestamp(0) with time zone not null default now()
+);
@@ -0,0 +1,3 @@
+-- Filename :migrations/000017_create_permissions_audit_table.down.sql
+
+drop table if exists permissions_audit;
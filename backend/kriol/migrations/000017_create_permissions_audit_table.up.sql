@@ -0,0 +1,13 @@
+-- Filename :migrations/000017_create_permissions_audit_table.up.sql
+
+--every grant and revoke of a permission, with who made it and when
+create table if not exists permissions_audit(
+    id bigserial primary key,
+    user_id bigint not null references users (id) on delete cascade,
+    code text not null,
+    action text not null check (action in ('grant', 'revoke')),
+    actor_id bigint references users (id) on delete set null,
+    created_at timestamp(0) with time zone not null default now()
+);
+
+create index if not exists permissions_audit_user_id_idx on permissions_audit (user_id, created_at);
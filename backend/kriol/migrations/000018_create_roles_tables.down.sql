@@ -0,0 +1,6 @@
+-- Filename :migrations/000018_create_roles_tables.down.sql
+
+drop table if exists users_roles;
+drop table if exists roles_parents;
+drop table if exists roles_permissions;
+drop table if exists roles;
@@ -0,0 +1,52 @@
+-- Filename :migrations/000018_create_roles_tables.up.sql
+
+--named groups of permission codes
+create table if not exists roles(
+    id bigserial primary key,
+    name text unique not null,
+    description text not null default '',
+    created_at timestamp(0) with time zone not null default now(),
+    version integer not null default 1
+);
+
+create table if not exists roles_permissions(
+    role_id bigint not null references roles (id) on delete cascade,
+    permission_id bigint not null references permissions (id) on delete cascade,
+    primary key (role_id, permission_id)
+);
+
+--a role inherits every permission of its parents
+create table if not exists roles_parents(
+    role_id bigint not null references roles (id) on delete cascade,
+    parent_id bigint not null references roles (id) on delete cascade,
+    primary key (role_id, parent_id),
+    check (role_id <> parent_id)
+);
+
+create table if not exists users_roles(
+    user_id bigint not null references users (id) on delete cascade,
+    role_id bigint not null references roles (id) on delete cascade,
+    primary key (user_id, role_id)
+);
+
+insert into roles (name, description)
+values ('viewer', 'Can read schools'),
+       ('editor', 'Can read and change schools'),
+       ('moderator', 'Looks after the school directory'),
+       ('admin', 'Can manage users and their access');
+
+insert into roles_permissions
+select roles.id, permissions.id from roles, permissions
+where (roles.name, permissions.code) in (
+    ('viewer', 'schools:read'),
+    ('editor', 'schools:write'),
+    ('admin', 'users:admin')
+);
+
+insert into roles_parents
+select child.id, parent.id from roles child, roles parent
+where (child.name, parent.name) in (
+    ('editor', 'viewer'),
+    ('moderator', 'editor'),
+    ('admin', 'moderator')
+);
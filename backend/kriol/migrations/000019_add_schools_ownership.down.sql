@@ -0,0 +1,6 @@
+-- Filename :migrations/000019_add_schools_ownership.down.sql
+
+delete from permissions where code = 'schools:admin';
+drop table if exists schools_collaborators;
+drop index if exists schools_owner_id_idx;
+alter table schools drop column if exists owner_id;
@@ -0,0 +1,21 @@
+-- Filename :migrations/000019_add_schools_ownership.up.sql
+
+--schools created before ownership existed have no owner
+alter table schools add column if not exists owner_id bigint references users (id) on delete set null;
+
+create index if not exists schools_owner_id_idx on schools (owner_id);
+
+--users the owner has shared a school with, they may edit it
+create table if not exists schools_collaborators(
+    school_id bigint not null references schools (id) on delete cascade,
+    user_id bigint not null references users (id) on delete cascade,
+    created_at timestamp(0) with time zone not null default now(),
+    primary key (school_id, user_id)
+);
+
+insert into permissions (code)
+values ('schools:admin');
+
+insert into roles_permissions
+select roles.id, permissions.id from roles, permissions
+where roles.name = 'moderator' and permissions.code = 'schools:admin';
@@ -0,0 +1,4 @@
+-- Filename :migrations/000020_create_invitations_table.down.sql
+
+drop table if exists invitations_permissions;
+drop table if exists invitations;
@@ -0,0 +1,20 @@
+-- Filename :migrations/000020_create_invitations_table.up.sql
+
+--an admin invites someone to register, the token is sent to the email address
+create table if not exists invitations(
+    id bigserial primary key,
+    hash bytea unique not null,
+    email citext not null,
+    invited_by bigint references users (id) on delete set null,
+    created_at timestamp(0) with time zone not null default now(),
+    expiry timestamp(0) with time zone not null,
+    accepted_at timestamp(0) with time zone,
+    user_id bigint references users (id) on delete set null
+);
+
+--the permissions the invited user gets when they register
+create table if not exists invitations_permissions(
+    invitation_id bigint not null references invitations (id) on delete cascade,
+    permission_id bigint not null references permissions (id) on delete cascade,
+    primary key (invitation_id, permission_id)
+);
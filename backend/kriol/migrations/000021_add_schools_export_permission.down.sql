@@ -0,0 +1,3 @@
+-- Filename :migrations/000021_add_schools_export_permission.down.sql
+
+delete from permissions where code = 'schools:export';
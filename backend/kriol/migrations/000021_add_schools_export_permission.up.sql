@@ -0,0 +1,5 @@
+-- Filename :migrations/000021_add_schools_export_permission.up.sql
+
+--exporting the whole directory is granted separately from reading it
+insert into permissions (code)
+values ('schools:export');
@@ -0,0 +1,5 @@
+-- Filename :migrations/000022_add_schools_location.down.sql
+drop index if exists schools_location_idx;
+alter table schools drop constraint if exists schools_location_check;
+alter table schools drop column if exists longitude;
+alter table schools drop column if exists latitude;
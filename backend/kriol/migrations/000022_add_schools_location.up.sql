@@ -0,0 +1,14 @@
+-- Filename :migrations/000022_add_schools_location.up.sql
+create extension if not exists cube;
+create extension if not exists earthdistance;
+
+--a school either has both coordinates or neither
+alter table schools add column if not exists latitude double precision;
+alter table schools add column if not exists longitude double precision;
+alter table schools add constraint schools_location_check check (
+    (latitude is null and longitude is null)
+    or (latitude between -90 and 90 and longitude between -180 and 180)
+);
+
+create index if not exists schools_location_idx on schools using gist (ll_to_earth(latitude, longitude))
+where latitude is not null;
@@ -0,0 +1,6 @@
+-- Filename :migrations/000023_add_schools_soft_delete.down.sql
+
+delete from schools where deleted_at is not null;
+drop index if exists schools_deleted_at_idx;
+alter table schools drop column if exists deleted_by;
+alter table schools drop column if exists deleted_at;
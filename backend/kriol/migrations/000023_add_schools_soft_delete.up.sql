@@ -0,0 +1,7 @@
+-- Filename :migrations/000023_add_schools_soft_delete.up.sql
+
+--deleted schools stay in the trash until they are restored or purged
+alter table schools add column if not exists deleted_at timestamp(0) with time zone;
+alter table schools add column if not exists deleted_by bigint references users (id) on delete set null;
+
+create index if not exists schools_deleted_at_idx on schools (deleted_at) where deleted_at is not null;
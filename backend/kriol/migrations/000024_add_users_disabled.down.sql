@@ -0,0 +1,3 @@
+-- Filename :migrations/000024_add_users_disabled.down.sql
+
+alter table users drop column if exists disabled;
@@ -0,0 +1,4 @@
+-- Filename :migrations/000024_add_users_disabled.up.sql
+
+--accounts suspended by an admin, kept apart from activation so the activation flow cannot undo it
+alter table users add column if not exists disabled boolean not null default false;
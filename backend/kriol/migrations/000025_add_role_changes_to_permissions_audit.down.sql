@@ -0,0 +1,6 @@
+-- Filename :migrations/000025_add_role_changes_to_permissions_audit.down.sql
+
+delete from permissions_audit where action in ('grant_role', 'revoke_role');
+alter table permissions_audit drop constraint if exists permissions_audit_action_check;
+alter table permissions_audit add constraint permissions_audit_action_check
+    check (action in ('grant', 'revoke'));
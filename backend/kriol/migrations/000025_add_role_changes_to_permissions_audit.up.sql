@@ -0,0 +1,6 @@
+-- Filename :migrations/000025_add_role_changes_to_permissions_audit.up.sql
+
+--roles given to or taken from a user are audited too, with the role name in code
+alter table permissions_audit drop constraint if exists permissions_audit_action_check;
+alter table permissions_audit add constraint permissions_audit_action_check
+    check (action in ('grant', 'revoke', 'grant_role', 'revoke_role'));
@@ -0,0 +1,3 @@
+-- Filename :migrations/000026_add_users_activated_at.down.sql
+
+alter table users drop column if exists activated_at;
@@ -0,0 +1,13 @@
+-- Filename :migrations/000026_add_users_activated_at.up.sql
+
+--when an account was first activated, the reaper only removes accounts where this is null
+alter table users add column if not exists activated_at timestamp(0) with time zone;
+
+--accounts that are activated, or that ever held a session, were activated at some point
+update users set activated_at = now()
+where activated_at is null
+and (activated or exists (
+    select 1 from tokens
+    where tokens.user_id = users.id
+    and tokens.scope <> 'activation'
+));
@@ -0,0 +1,7 @@
+-- Filename :migrations/000027_fix_schools_location_check.down.sql
+
+alter table schools drop constraint if exists schools_location_check;
+alter table schools add constraint schools_location_check check (
+    (latitude is null and longitude is null)
+    or (latitude between -90 and 90 and longitude between -180 and 180)
+);
@@ -0,0 +1,12 @@
+-- Filename :migrations/000027_fix_schools_location_check.up.sql
+
+--a null coordinate made the old check null, which passes, so one coordinate on its own was accepted
+--rows that slipped through lose the half of a location they have
+update schools set latitude = null, longitude = null
+where (latitude is null) <> (longitude is null);
+
+alter table schools drop constraint if exists schools_location_check;
+alter table schools add constraint schools_location_check check (
+    (latitude is null) = (longitude is null)
+    and (latitude is null or (latitude between -90 and 90 and longitude between -180 and 180))
+);